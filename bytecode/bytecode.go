@@ -0,0 +1,202 @@
+// Package bytecode defines a compact binary encoding for the bytecode
+// instructions generated by the 'parser' package, and an object file
+// format which holds an assembled program, so a program can be assembled
+// once and executed many times.
+//
+// Every instruction is encoded as a single 32-bit word:
+//
+//   31  30      24 23    20 19    16 15                0
+//  +---+----------+--------+--------+------------------+
+//  | K |  opcode  |   A    |   B    |                  |
+//  +---+----------+--------+--------+------------------+
+//                          |<-------- IMM20 ---------->|
+//               |<----------------- IMM24 ------------>|
+//
+//  - opcode is the 7-bit opcode of the instruction.
+//  - A and B are 4-bit register fields, e.g., ADD A,B or LD A,[B].
+//  - Instructions with a register and an immediate value (e.g., LDI A,K or
+//    ST [K],A) hold the value in the signed 20-bit IMM20 field.
+//  - Instructions with only an immediate value (a jump target, a shape or
+//    a string table index) hold it in the signed 24-bit IMM24 field.
+//  - If the immediate value does not fit in its field, the K bit is set
+//    and the field instead holds the unsigned index of the value in the
+//    program's constant pool, a table of 32-bit values stored with the
+//    code.
+//
+// Unused fields are zero, and Decode rejects words which Encode would not
+// produce. Decode(Encode(code)) returns the original instructions.
+package bytecode
+
+import (
+    "fmt"
+    "gvm/instructions"
+)
+
+// Instruction word fields
+const (
+    CONSTANT_BIT = 1 << 31 // the immediate field is a constant pool index
+    OPCODE_SHIFT = 24
+    OPCODE_MASK  = 0x7F
+    A_SHIFT      = 20
+    B_SHIFT      = 16
+    REG_MASK     = 0xF
+    IMM20_BITS   = 20
+    IMM24_BITS   = 24
+)
+
+// Encode encodes the instructions in code as 32-bit words. Immediate
+// values which do not fit in their field are stored in the returned
+// constant pool. An error is returned for an invalid opcode or a
+// register which does not fit in its field.
+func Encode(code []instructions.Instruction) (words []uint32, constants []int32, err error) {
+    words = make([]uint32, len(code))
+    pool := make(map[int32]int) // constant pool index of each value
+    for addr, instr := range code {
+        word, err := encode(instr, func(value int32) uint32 {
+            index, ok := pool[value]
+            if !ok {
+                index = len(constants)
+                pool[value] = index
+                constants = append(constants, value)
+            }
+            return uint32(index)
+        })
+        if err != nil {
+            return nil, nil, fmt.Errorf("%v at addr %d",err,addr)
+        }
+        words[addr] = word
+    }
+    if len(constants) > 1 << IMM20_BITS {
+        return nil, nil, fmt.Errorf("gvm: encode: too many constants (%d, the limit is %d)",len(constants),1 << IMM20_BITS)
+    }
+    return words, constants, nil
+}
+
+// encode encodes a single instruction, calling constant to place an
+// immediate value which does not fit in its field in the constant pool.
+func encode(instr instructions.Instruction, constant func(int32) uint32) (uint32, error) {
+    if errInstr, ok := instr.(*instructions.Error); ok {
+        return 0, fmt.Errorf("gvm: encode: error instruction: %v",errInstr.ErrorType)
+    }
+    opcode, ok := instructions.Opcodes[instr.GetOpCode()]
+    if !ok || instr.GetOpCode() & ^OPCODE_MASK != 0 {
+        return 0, fmt.Errorf("gvm: encode: invalid opcode %#04x",instr.GetOpCode())
+    }
+    word := uint32(instr.GetOpCode()) << OPCODE_SHIFT
+    arg1, arg2 := instr.GetArg1(), instr.GetArg2()
+
+    // register fields
+    register := func(value int32, shift uint) error {
+        if value < 0 || value > REG_MASK {
+            return fmt.Errorf("gvm: encode: %s: invalid register %d",opcode.Mnemonic,value)
+        }
+        word |= uint32(value) << shift
+        return nil
+    }
+    // immediate field
+    immediate := func(value int32, bits uint) {
+        if value >= -1 << (bits - 1) && value < 1 << (bits - 1) {
+            word |= uint32(value) & (1 << bits - 1)
+        } else {
+            word |= CONSTANT_BIT | constant(value)
+        }
+    }
+
+    var err error
+    switch opcode.Form {
+    case instructions.REG:
+        err = register(arg1, A_SHIFT)
+    case instructions.REG_REG, instructions.LOAD, instructions.STORE:
+        if err = register(arg1, A_SHIFT); err == nil {
+            err = register(arg2, B_SHIFT)
+        }
+    case instructions.REG_IMM, instructions.LOAD_ADDR:
+        if err = register(arg1, A_SHIFT); err == nil {
+            immediate(arg2, IMM20_BITS)
+        }
+    case instructions.STORE_ADDR:
+        // ST [K],Rr: the register is held in A
+        if err = register(arg2, A_SHIFT); err == nil {
+            immediate(arg1, IMM20_BITS)
+        }
+    case instructions.ADDR, instructions.SHAPE, instructions.STRING:
+        immediate(arg1, IMM24_BITS)
+    }
+    return word, err
+}
+
+// Decode decodes the 32-bit words of a program, with its constant pool,
+// into bytecode instructions. An error is returned for a word which
+// Encode would not produce.
+func Decode(words []uint32, constants []int32) ([]instructions.Instruction, error) {
+    code := make([]instructions.Instruction, len(words))
+    for addr, word := range words {
+        instr, err := decode(word, constants)
+        if err != nil {
+            return nil, fmt.Errorf("%v at addr %d",err,addr)
+        }
+        code[addr] = instr
+    }
+    return code, nil
+}
+
+// decode decodes a single instruction word.
+func decode(word uint32, constants []int32) (instructions.Instruction, error) {
+    opcodeValue := int32(word >> OPCODE_SHIFT & OPCODE_MASK)
+    opcode, ok := instructions.Opcodes[opcodeValue]
+    if !ok {
+        return nil, fmt.Errorf("gvm: decode: invalid opcode %#04x in word %#08x",opcodeValue,word)
+    }
+    a := int32(word >> A_SHIFT & REG_MASK)
+    b := int32(word >> B_SHIFT & REG_MASK)
+    usedBits := uint32(OPCODE_MASK << OPCODE_SHIFT)
+
+    // immediate field
+    var immErr error
+    immediate := func(bits uint) int32 {
+        field := word & (1 << bits - 1)
+        usedBits |= 1 << bits - 1
+        if word & CONSTANT_BIT == 0 {
+            // sign extend the field
+            return int32(field << (32 - bits)) >> (32 - bits)
+        }
+        usedBits |= CONSTANT_BIT
+        if int(field) >= len(constants) {
+            immErr = fmt.Errorf("gvm: decode: constant pool index %d out of range in word %#08x",field,word)
+            return 0
+        }
+        value := constants[field]
+        if value >= -1 << (bits - 1) && value < 1 << (bits - 1) {
+            // Encode would have held the value in the field
+            immErr = fmt.Errorf("gvm: decode: constant %d does not need the constant pool in word %#08x",value,word)
+        }
+        return value
+    }
+
+    var instr instructions.Instruction
+    switch opcode.Form {
+    case instructions.NONE:
+        instr = instructions.NewNullaryInstruction(opcodeValue)
+    case instructions.REG:
+        usedBits |= REG_MASK << A_SHIFT
+        instr = instructions.NewUnaryInstruction(opcodeValue, a)
+    case instructions.REG_REG, instructions.LOAD, instructions.STORE:
+        usedBits |= REG_MASK << A_SHIFT | REG_MASK << B_SHIFT
+        instr = instructions.NewBinaryInstruction(opcodeValue, a, b)
+    case instructions.REG_IMM, instructions.LOAD_ADDR:
+        usedBits |= REG_MASK << A_SHIFT
+        instr = instructions.NewBinaryInstruction(opcodeValue, a, immediate(IMM20_BITS))
+    case instructions.STORE_ADDR:
+        usedBits |= REG_MASK << A_SHIFT
+        instr = instructions.NewBinaryInstruction(opcodeValue, immediate(IMM20_BITS), a)
+    case instructions.ADDR, instructions.SHAPE, instructions.STRING:
+        instr = instructions.NewUnaryInstruction(opcodeValue, immediate(IMM24_BITS))
+    }
+    if immErr != nil {
+        return nil, immErr
+    }
+    if word & ^usedBits != 0 {
+        return nil, fmt.Errorf("gvm: decode: %s: unused bits set in word %#08x",opcode.Mnemonic,word)
+    }
+    return instr, nil
+}
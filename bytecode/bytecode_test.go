@@ -0,0 +1,200 @@
+package bytecode
+
+import (
+    "os"
+    "bytes"
+    "strings"
+    "testing"
+    "path/filepath"
+    "gvm/parser"
+    "gvm/instructions"
+)
+
+// assemble assembles a Susan program into an Object, or returns nil if
+// the program has syntax errors.
+func assemble(source string) *Object {
+    code, strs, labels, err := parser.Assemble(strings.NewReader(source))
+    if err != nil {
+        return nil
+    }
+    return &Object{Code: code, Strings: strs, Labels: labels}
+}
+
+// equal reports whether two programs hold the same instructions.
+func equal(code1, code2 []instructions.Instruction) bool {
+    if len(code1) != len(code2) {
+        return false
+    }
+    for i := range code1 {
+        if code1[i].String() != code2[i].String() {
+            return false
+        }
+    }
+    return true
+}
+
+// Tests the encoding of each instruction form, and of immediate values
+// which do and do not fit in their field.
+func TestEncode(t *testing.T) {
+    testCases := []struct {
+        input string
+        word uint32
+        constants []int32
+    }{
+        {"LDI r1, 8", 0x01100008, nil},
+        {"LDI r1, -1", 0x011FFFFF, nil},
+        {"LDI r9, 524287", 0x0197FFFF, nil},
+        {"LDI r9, -524288", 0x01980000, nil},
+        {"LDI r9, 524288", 0x81900000, []int32{524288}},
+        {"LDI r2, -2147483648", 0x81200000, []int32{-2147483648}},
+        {"ADD r1, r2", 0x17120000, nil},
+        {"STDOUT r3", 0x00300000, nil},
+        {"HALT", 0x49000000, nil},
+        {"HALT r4", 0x4A400000, nil},
+        {"LD r1, [r2]", 0x30120000, nil},
+        {"LD r1, [8]", 0x31100008, nil},
+        {"ST [r2], r1", 0x32210000, nil},
+        {"ST [8], r3", 0x33300008, nil},
+        {"SHR r5, 31", 0x3E50001F, nil},
+        {"JUMP 8388607", 0x027FFFFF, nil},
+        {"JUMP 8388608", 0x82000000, []int32{8388608}},
+        {"DRAW $bird", 0x19000002, nil},
+        {"PRINT \"hi\"", 0x4F000000, nil},
+    }
+
+    for _, testCase := range testCases {
+        obj := assemble(testCase.input)
+        if obj == nil || len(obj.Code) != 1 {
+            t.Fatalf("FAIL: %s: error returned from valid instruction", testCase.input)
+        }
+        words, constants, err := Encode(obj.Code)
+        if err != nil || words[0] != testCase.word || !equalConstants(constants, testCase.constants) {
+            t.Errorf("FAIL: %s: expected %#08x %v, got %#08x %v: %v", testCase.input, testCase.word, testCase.constants, words[0], constants, err)
+            continue
+        }
+        code, err := Decode(words, constants)
+        if err != nil || !equal(code, obj.Code) {
+            t.Errorf("FAIL: %s: expected %v after decoding, got %v: %v", testCase.input, obj.Code, code, err)
+        }
+    }
+
+    // each constant is stored once
+    obj := assemble("LDI r1, 1000000\nLDI r2, 1000000\nLDI r3, -1000000")
+    words, constants, err := Encode(obj.Code)
+    if err != nil || !equalConstants(constants, []int32{1000000, -1000000}) || words[1] != 0x81200000 || words[2] != 0x81300001 {
+        t.Errorf("FAIL: expected 2 constants, got %v %#08x: %v", constants, words, err)
+    }
+}
+
+func equalConstants(c1, c2 []int32) bool {
+    if len(c1) != len(c2) {
+        return false
+    }
+    for i := range c1 {
+        if c1[i] != c2[i] {
+            return false
+        }
+    }
+    return true
+}
+
+// Tests that instructions which cannot be encoded, and words which Encode
+// would not produce, are rejected.
+func TestInvalid(t *testing.T) {
+    invalidCode := []instructions.Instruction{
+        instructions.NewNullaryInstruction(0x99),
+        instructions.NewNullaryInstruction(0x1ED),
+        instructions.NewBinaryInstruction(0x17, 1, 16),
+        instructions.NewUnaryInstruction(0x34, -1),
+        instructions.NewError(os.ErrInvalid),
+    }
+    for _, instr := range invalidCode {
+        if words, _, err := Encode([]instructions.Instruction{instr}); err == nil {
+            t.Errorf("FAIL: %v: expected an error, got %#08x", instr, words)
+        }
+    }
+
+    invalidWords := []struct {
+        word uint32
+        constants []int32
+    }{
+        {0x7F000000, nil},             // invalid opcode
+        {0x17120001, nil},             // ADD with an immediate
+        {0x49100000, nil},             // HALT with a register
+        {0x97120000, nil},             // ADD with the constant bit
+        {0x81100000, nil},             // constant pool index out of range
+        {0x81100000, []int32{5}},      // constant which fits in the field
+    }
+    for _, testCase := range invalidWords {
+        if code, err := Decode([]uint32{testCase.word}, testCase.constants); err == nil {
+            t.Errorf("FAIL: %#08x: expected an error, got %v", testCase.word, code)
+        }
+    }
+}
+
+// Tests that every valid sample program is unchanged after it is written
+// to an object file and read back.
+func TestObject(t *testing.T) {
+    files, _ := filepath.Glob("../vm/testdata/test*")
+    samples, _ := filepath.Glob("../sun/*")
+    tested := 0
+    for _, file := range append(files, samples...) {
+        source, err := os.ReadFile(file)
+        if err != nil || strings.HasSuffix(file, ".md") {
+            continue
+        }
+        obj := assemble(string(source))
+        if obj == nil {
+            continue // programs with syntax errors
+        }
+        var buf bytes.Buffer
+        if err := Write(&buf, obj); err != nil {
+            t.Errorf("FAIL: %s: error returned from Write: %v", file, err)
+            continue
+        }
+        if !bytes.HasPrefix(buf.Bytes(), []byte(MAGIC)) || buf.Len() < 8 + 4 * len(obj.Code) {
+            t.Errorf("FAIL: %s: unexpected object file % x", file, buf.Bytes())
+        }
+        obj2, err := Read(&buf)
+        if err != nil || !equal(obj.Code, obj2.Code) || strings.Join(obj.Strings, "\x00") != strings.Join(obj2.Strings, "\x00") || len(obj.Labels) != len(obj2.Labels) {
+            t.Errorf("FAIL: %s: program changed by the object file: %v", file, err)
+            continue
+        }
+        for name, addr := range obj.Labels {
+            if obj2.Labels[name] != addr {
+                t.Errorf("FAIL: %s: label %s changed from %d to %d", file, name, addr, obj2.Labels[name])
+            }
+        }
+        tested++
+    }
+    if tested == 0 {
+        t.Errorf("FAIL: no sample programs found")
+    }
+}
+
+// Tests that invalid object files are rejected.
+func TestReadErrors(t *testing.T) {
+    var buf bytes.Buffer
+    if err := Write(&buf, assemble("loop: LDI r1, 3000000\nPRINT \"hi\"\nJUMP loop")); err != nil {
+        t.Fatalf("FAIL: error returned from Write: %v", err)
+    }
+    valid := buf.Bytes()
+
+    testCases := []struct {
+        name string
+        data []byte
+        err string
+    }{
+        {"empty", nil, "unexpected end of file"},
+        {"magic", append([]byte("\x7fELF"), valid[4:]...), "bad magic number"},
+        {"version", append(append([]byte{}, valid[:4]...), append([]byte{2, 0}, valid[6:]...)...), "unsupported object file version 2"},
+        {"truncated", valid[:len(valid) - 1], "unexpected end of file"},
+        {"trailing data", append(append([]byte{}, valid...), 0), "unexpected data"},
+        {"size", append(append([]byte{}, valid[:8]...), 0xFF, 0xFF, 0xFF, 0xFF), "exceeds"},
+    }
+    for _, testCase := range testCases {
+        if _, err := Read(bytes.NewReader(testCase.data)); err == nil || !strings.Contains(err.Error(), testCase.err) {
+            t.Errorf("FAIL: %s: expected error %q, got: %v", testCase.name, testCase.err, err)
+        }
+    }
+}
@@ -0,0 +1,171 @@
+package bytecode
+
+import (
+    "io"
+    "fmt"
+    "sort"
+    "bufio"
+    "errors"
+    "encoding/binary"
+    "gvm/instructions"
+)
+
+// An object file holds an assembled Susan program. All values are stored
+// in little-endian byte order:
+//
+//  header     magic "\x7fSBC", version (uint16), reserved (uint16, zero)
+//  code       instruction count (uint32), one 32-bit word per instruction
+//  constants  constant count (uint32), one int32 per constant
+//  strings    string count (uint32), each string's length (uint32) and bytes
+//  labels     label count (uint32), each label's name length (uint32), name
+//             and address (int32)
+//
+// The labels are not needed to run the program; they are kept so that it
+// can be disassembled with its original labels.
+const (
+    MAGIC   = "\x7fSBC"
+    VERSION = 1
+
+    // MAX_COUNT limits the size of each section and of each string read
+    // from an object file, so a corrupt file cannot exhaust the memory.
+    MAX_COUNT = 1 << 20
+)
+
+// Object is a program read from or written to an object file.
+type Object struct {
+    Code []instructions.Instruction
+    Strings []string
+    Labels map[string]int32
+}
+
+// Write encodes the program held in obj and writes it to w in the object
+// file format.
+func Write(w io.Writer, obj *Object) error {
+    words, constants, err := Encode(obj.Code)
+    if err != nil {
+        return err
+    }
+    out := bufio.NewWriter(w)
+    out.WriteString(MAGIC)
+    binary.Write(out, binary.LittleEndian, []uint16{VERSION, 0})
+
+    binary.Write(out, binary.LittleEndian, uint32(len(words)))
+    binary.Write(out, binary.LittleEndian, words)
+    binary.Write(out, binary.LittleEndian, uint32(len(constants)))
+    binary.Write(out, binary.LittleEndian, constants)
+    binary.Write(out, binary.LittleEndian, uint32(len(obj.Strings)))
+    for _, s := range obj.Strings {
+        binary.Write(out, binary.LittleEndian, uint32(len(s)))
+        out.WriteString(s)
+    }
+
+    // labels are written in alphabetical order so the file is reproducible
+    names := make([]string, 0, len(obj.Labels))
+    for name := range obj.Labels {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    binary.Write(out, binary.LittleEndian, uint32(len(names)))
+    for _, name := range names {
+        binary.Write(out, binary.LittleEndian, uint32(len(name)))
+        out.WriteString(name)
+        binary.Write(out, binary.LittleEndian, obj.Labels[name])
+    }
+    if err := out.Flush(); err != nil {
+        return fmt.Errorf("gvm: failed to write object file: %v",err)
+    }
+    return nil
+}
+
+// Read reads a program in the object file format from r and decodes it.
+// An error is returned if r does not hold a valid object file of the
+// current VERSION.
+func Read(r io.Reader) (*Object, error) {
+    in := &reader{r: bufio.NewReader(r)}
+    magic := in.bytes(len(MAGIC))
+    if in.err == nil && string(magic) != MAGIC {
+        return nil, errors.New("gvm: not a Susan object file (bad magic number)")
+    }
+    version, reserved := in.uint16(), in.uint16()
+    if in.err == nil && (version != VERSION || reserved != 0) {
+        return nil, fmt.Errorf("gvm: unsupported object file version %d (expected %d)",version,VERSION)
+    }
+
+    words := make([]uint32, in.count())
+    for i := range words {
+        words[i] = in.uint32()
+    }
+    constants := make([]int32, in.count())
+    for i := range constants {
+        constants[i] = int32(in.uint32())
+    }
+    obj := &Object{Strings: make([]string, in.count()), Labels: make(map[string]int32)}
+    for i := range obj.Strings {
+        obj.Strings[i] = string(in.bytes(in.count()))
+    }
+    labels := in.count()
+    for i := 0; i < labels; i++ {
+        name := string(in.bytes(in.count()))
+        obj.Labels[name] = int32(in.uint32())
+    }
+    if in.err != nil {
+        return nil, in.err
+    }
+    if _, err := in.r.ReadByte(); err != io.EOF {
+        return nil, errors.New("gvm: corrupt object file: unexpected data after the labels")
+    }
+
+    code, err := Decode(words, constants)
+    if err != nil {
+        return nil, err
+    }
+    obj.Code = code
+    return obj, nil
+}
+
+// reader reads the values of an object file, holding the first error so
+// that the values can be read without checking each one.
+type reader struct {
+    r *bufio.Reader
+    err error
+}
+
+func (in *reader) bytes(n int) []byte {
+    if in.err != nil {
+        return nil
+    }
+    buf := make([]byte, n)
+    if _, err := io.ReadFull(in.r, buf); err != nil {
+        in.err = errors.New("gvm: corrupt object file: unexpected end of file")
+        return nil
+    }
+    return buf
+}
+
+func (in *reader) uint16() uint16 {
+    buf := in.bytes(2)
+    if buf == nil {
+        return 0
+    }
+    return binary.LittleEndian.Uint16(buf)
+}
+
+func (in *reader) uint32() uint32 {
+    buf := in.bytes(4)
+    if buf == nil {
+        return 0
+    }
+    return binary.LittleEndian.Uint32(buf)
+}
+
+// count reads the size of a section or of a string.
+func (in *reader) count() int {
+    n := in.uint32()
+    if n > MAX_COUNT && in.err == nil {
+        in.err = fmt.Errorf("gvm: corrupt object file: size %d exceeds %d",n,MAX_COUNT)
+    }
+    if in.err != nil {
+        return 0
+    }
+    return int(n)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+    "io"
+    "os"
+    "fmt"
+    "flag"
+    "bufio"
+    "bytes"
+    "errors"
+    "time"
+    "strings"
+    "path/filepath"
+    "gvm/vm"
+    "gvm/bytecode"
+    "gvm/interpreter"
+)
+
+// Exit codes of the gvm subcommands. A program which runs to completion
+// exits with its own exit status (the status given to HALT), mapped by 
+// exitStatus. A program status of 1, 2 or 3 cannot be told apart from 
+// the codes below, so programs whose statuses must be told apart from a
+// failure of gvm itself should use statuses above 3.
+const (
+    EXIT_OK      = 0
+    EXIT_FAILED  = 1 // the program stopped with a runtime error
+    EXIT_USAGE   = 2 // invalid command, flag or number of arguments
+    EXIT_INVALID = 3 // the program could not be read, assembled or written
+
+    EXIT_STATUS_MAX = 255 // the largest exit code kept by the OS
+)
+
+const USAGE = `usage: gvm [command [flags] file]
+
+With no command, gvm starts the interactive prompt. Commands:
+  run prog.sus                assemble and run a Susan program
+  asm prog.sus [-o prog.sbc]  assemble a Susan program into an object file
+  exec prog.sbc               run an object file
+  disasm prog.sbc|prog.sus    print the disassembly of a program
+  help                        print this message
+
+Run 'gvm command -h' for the flags of a command.
+`
+
+// traceFlag is the value of the --trace flag: --trace alone selects the
+// text format, and --trace=text or --trace=json select a format.
+type traceFlag string
+
+func (t *traceFlag) String() string {
+    return string(*t)
+}
+
+func (t *traceFlag) Set(value string) error {
+    switch value {
+    case "true":
+        *t = vm.TRACE_TEXT
+    case "false":
+        *t = ""
+    case vm.TRACE_TEXT, vm.TRACE_JSON:
+        *t = traceFlag(value)
+    default:
+        return fmt.Errorf("use --trace, --trace=%s or --trace=%s",vm.TRACE_TEXT,vm.TRACE_JSON)
+    }
+    return nil
+}
+
+func (t *traceFlag) IsBoolFlag() bool {
+    return true
+}
+
+// command runs the gvm subcommand given by args (the command line without
+// the program name) and returns the exit code. Program output is written
+// to stdout, and errors, usage messages and traces to stderr.
+func command(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+    name, args := args[0], args[1:]
+    flags := flag.NewFlagSet("gvm " + name, flag.ContinueOnError)
+    flags.SetOutput(stderr)
+
+    var trace traceFlag
+    var maxSteps int64
+    var timeout time.Duration
+    var output string
+    switch name {
+    case "run", "exec":
+        flags.Var(&trace, "trace", "write a trace of every instruction executed to STDERR (text or json)")
+        flags.Int64Var(&maxSteps, "max-steps", interpreter.MAX_STEPS, "stop the program after this many instructions (0 for no limit)")
+        flags.DurationVar(&timeout, "timeout", 0, "stop the program after this long, e.g. 5s (0 for no limit)")
+    case "asm":
+        flags.StringVar(&output, "o", "", "the object file to write (default: the program file with the extension .sbc)")
+    case "disasm":
+    case "help", "-h", "-help", "--help":
+        fmt.Fprint(stdout, USAGE)
+        return EXIT_OK
+    default:
+        fmt.Fprintf(stderr, "gvm: unknown command '%s'\n%s",name,USAGE)
+        return EXIT_USAGE
+    }
+
+    // flags may be given before or after the file name
+    var files []string
+    for {
+        if err := flags.Parse(args); err != nil {
+            if errors.Is(err, flag.ErrHelp) {
+                return EXIT_OK
+            }
+            return EXIT_USAGE
+        }
+        if flags.NArg() == 0 {
+            break
+        }
+        files = append(files, flags.Arg(0))
+        args = flags.Args()[1:]
+    }
+    if len(files) != 1 {
+        fmt.Fprintf(stderr, "gvm %s: expected one file, got %d\n",name,len(files))
+        return EXIT_USAGE
+    }
+    filename := files[0]
+
+    virtualMachine := vm.NewVirtualMachine(stdout)
+    virtualMachine.Interpreter.Input = stdin
+    virtualMachine.Interpreter.MaxSteps = maxSteps
+    virtualMachine.Interpreter.Timeout = timeout
+    if trace != "" {
+        virtualMachine.Trace = stderr
+        virtualMachine.TraceFormat = string(trace)
+    }
+
+    var err error
+    switch name {
+    case "run":
+        err = loadFile(filename, virtualMachine.Load)
+    case "exec":
+        err = loadFile(filename, virtualMachine.LoadObject)
+    case "asm", "disasm":
+        err = loadFile(filename, func(r io.Reader) error {
+            return load(virtualMachine, r)
+        })
+    }
+    if err != nil {
+        fmt.Fprintln(stderr, err)
+        return EXIT_INVALID
+    }
+
+    switch name {
+    case "asm":
+        if output == "" {
+            output = strings.TrimSuffix(filename, filepath.Ext(filename)) + ".sbc"
+        }
+        if err := writeObject(virtualMachine, output); err != nil {
+            fmt.Fprintln(stderr, err)
+            return EXIT_INVALID
+        }
+        return EXIT_OK
+    case "disasm":
+        if err := virtualMachine.Disassemble(stdout); err != nil {
+            fmt.Fprintln(stderr, err)
+            return EXIT_INVALID
+        }
+        return EXIT_OK
+    }
+    return exitCode(virtualMachine.Run(), stderr)
+}
+
+// exitCode reports an error returned by a program run to stderr and
+// returns the program's exit code.
+func exitCode(err error, stderr io.Writer) int {
+    var exitErr *vm.ExitError
+    switch {
+    case err == nil:
+        return EXIT_OK
+    case errors.As(err, &exitErr):
+        return exitStatus(exitErr.Status)
+    default:
+        fmt.Fprintln(stderr, err)
+        return EXIT_FAILED
+    }
+}
+
+// exitStatus maps a program's exit status to a process exit code. Only 
+// the low 8 bits of an exit code are kept, so a status outside 0 to 
+// EXIT_STATUS_MAX (e.g., 256, which would exit 0) exits with 
+// EXIT_STATUS_MAX instead, and a non-zero status never exits 0.
+func exitStatus(status int32) int {
+    if status < 0 || status > EXIT_STATUS_MAX {
+        return EXIT_STATUS_MAX
+    }
+    return int(status)
+}
+
+// loadFile opens the file filename and loads its contents with load.
+func loadFile(filename string, load func(io.Reader) error) error {
+    file, err := os.Open(filename)
+    if err != nil {
+        return fmt.Errorf("gvm: failed to open file: %v",err)
+    }
+    defer file.Close()
+    return load(file)
+}
+
+// load loads a program which is either an object file or source code,
+// depending on whether it starts with the object file magic number.
+func load(virtualMachine *vm.VirtualMachine, r io.Reader) error {
+    in := bufio.NewReader(r)
+    if file, ok := r.(interface{ Name() string }); ok {
+        virtualMachine.File = file.Name()
+    }
+    magic, _ := in.Peek(len(bytecode.MAGIC))
+    if bytes.Equal(magic, []byte(bytecode.MAGIC)) {
+        return virtualMachine.LoadObject(in)
+    }
+    return virtualMachine.Load(in)
+}
+
+// writeObject writes the program loaded into the virtual machine to the
+// object file filename.
+func writeObject(virtualMachine *vm.VirtualMachine, filename string) error {
+    file, err := os.Create(filename)
+    if err != nil {
+        return fmt.Errorf("gvm: failed to create object file: %v",err)
+    }
+    err = virtualMachine.WriteObject(file)
+    if closeErr := file.Close(); err == nil && closeErr != nil {
+        err = fmt.Errorf("gvm: failed to write object file: %v",closeErr)
+    }
+    if err != nil {
+        os.Remove(filename) // do not leave a partial object file behind
+    }
+    return err
+}
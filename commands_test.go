@@ -0,0 +1,76 @@
+package main
+
+import (
+    "os"
+    "strings"
+    "testing"
+    "path/filepath"
+)
+
+// Tests that each command writes the expected output and errors and exits
+// with the expected exit code.
+func TestCommands(t *testing.T) {
+    dir := t.TempDir()
+    write := func(name, source string) string {
+        file := filepath.Join(dir, name)
+        if err := os.WriteFile(file, []byte(source), 0644); err != nil {
+            t.Fatalf("FAIL: %v", err)
+        }
+        return file
+    }
+    hello := write("hello.sus", "loop: STDIN r1\nCMP r1, r9\nJLT done\nPRINTD r1\nJUMP loop\ndone: HALT\n")
+    status := write("status.sus", "LDI r1, 7\nHALT r1\n")
+    large := write("large.sus", "LDI r1, 256\nHALT r1\n")
+    negative := write("negative.sus", "LDI r1, -1\nHALT r1\n")
+    divide := write("divide.sus", "DIV r1, r2\n")
+    syntax := write("syntax.sus", "LDI r1\n")
+    object := filepath.Join(dir, "hello.sbc")
+
+    testCases := []struct {
+        args string
+        code int
+        stdout string
+        stderr string
+    }{
+        {"run " + hello, EXIT_OK, "123", ""},
+        {"run --trace " + hello, EXIT_OK, "123", "     1  addr 0: STDIN r1                 R1: 0 -> 1\n"},
+        {"run " + hello + " --trace=json", EXIT_OK, "123", `{"step":1,"pc":0,"instr":"STDIN r1","changes":[{"reg":"R1","old":0,"new":1}]}`},
+        {"run --max-steps 3 " + hello, EXIT_FAILED, "", "step limit (3)"},
+        {"run " + status, 7, "", ""},
+        {"run " + large, EXIT_STATUS_MAX, "", ""},
+        {"run " + negative, EXIT_STATUS_MAX, "", ""},
+        {"run " + divide, EXIT_FAILED, "", "divide.sus:1:1: DIV at addr 0: divide by zero"},
+        {"run " + syntax, EXIT_INVALID, "", "syntax.sus:1:"},
+        {"run " + filepath.Join(dir, "missing.sus"), EXIT_INVALID, "", "failed to open file"},
+        {"run", EXIT_USAGE, "", "expected one file, got 0"},
+        {"run " + hello + " " + status, EXIT_USAGE, "", "expected one file, got 2"},
+        {"run --trace=xml " + hello, EXIT_USAGE, "", "use --trace"},
+        {"asm " + hello + " -o " + object, EXIT_OK, "", ""},
+        {"exec " + object, EXIT_OK, "123", ""},
+        {"exec " + hello, EXIT_INVALID, "", "bad magic number"},
+        {"disasm " + object, EXIT_OK, "loop:\n       STDIN r1                 ; 0\n", ""},
+        {"disasm " + hello, EXIT_OK, "       JLT done                 ; 2\n", ""},
+        {"asm " + status, EXIT_OK, "", ""},
+        {"exec " + filepath.Join(dir, "status.sbc"), 7, "", ""},
+        {"asm " + syntax + " -o " + filepath.Join(dir, "syntax.sbc"), EXIT_INVALID, "", "syntax.sus:1:"},
+        {"help", EXIT_OK, "usage: gvm", ""},
+        {"compile " + hello, EXIT_USAGE, "", "unknown command 'compile'"},
+    }
+
+    for _, testCase := range testCases {
+        var stdout, stderr strings.Builder
+        code := command(strings.Fields(testCase.args), strings.NewReader("1 2 3 -1"), &stdout, &stderr)
+        if code != testCase.code {
+            t.Errorf("FAIL: %s: expected exit code %d, got %d: %s", testCase.args, testCase.code, code, stderr.String())
+        }
+        if !strings.Contains(stdout.String(), testCase.stdout) || (testCase.stdout == "" && stdout.Len() != 0) {
+            t.Errorf("FAIL: %s: expected output %q, got %q", testCase.args, testCase.stdout, stdout.String())
+        }
+        if !strings.Contains(stderr.String(), testCase.stderr) || (testCase.stderr == "" && stderr.Len() != 0) {
+            t.Errorf("FAIL: %s: expected errors %q, got %q", testCase.args, testCase.stderr, stderr.String())
+        }
+    }
+    if _, err := os.Stat(filepath.Join(dir, "syntax.sbc")); err == nil {
+        t.Errorf("FAIL: object file written for a program with syntax errors")
+    }
+}
@@ -0,0 +1,376 @@
+// Package debugger implements an interactive debugger for Susan programs
+// loaded into a VirtualMachine as defined in the 'vm' package. The
+// debugger reads commands one line at a time and drives the interpreter
+// with its single-step API. Breakpoints are held by the interpreter and
+// checked in its dispatch loop, so continuing to a breakpoint does not
+// re-run the program.
+//
+// Commands:
+//  - step [n]               execute the next n instructions (default 1), or
+//                           until a breakpoint
+//  - next                   execute the next instruction, stepping over CALLs
+//  - continue               run until a breakpoint or the end of the program
+//  - break [addr|label]     set a breakpoint, or list the breakpoints
+//  - delete [addr|label]    delete a breakpoint, or every breakpoint
+//  - regs                   print the PC, registers, SP and status flags
+//  - mem [addr [count]]     print count words of data memory (default 0 8)
+//  - list [addr|label]      list the instructions around addr (default PC)
+//  - disasm [addr|label]    list the disassembled instructions around addr
+//  - set rN value           write value to register rN
+//  - help, quit
+package debugger
+
+import (
+    "fmt"
+    "io"
+    "bufio"
+    "errors"
+    "sort"
+    "strconv"
+    "strings"
+    "gvm/vm"
+    "gvm/interpreter"
+)
+
+// LIST_SIZE is the number of instructions printed by the list command.
+const LIST_SIZE = 10
+
+// MEM_SIZE is the default number of words printed by the mem command.
+const MEM_SIZE = 8
+
+type Debugger struct {
+    // VM is the virtual machine holding the program being debugged.
+    VM *vm.VirtualMachine
+
+    // Commands are read from In, and the debugger's responses are written
+    // to Out.
+    In *bufio.Scanner
+    Out io.Writer
+}
+
+// New initializes a debugger for the program loaded into virtualMachine,
+// which reads commands from in and writes to out.
+func New(virtualMachine *vm.VirtualMachine, in *bufio.Scanner, out io.Writer) *Debugger {
+    return &Debugger{VM: virtualMachine, In: in, Out: out}
+}
+
+// Run prepares the loaded program to execute from its first instruction
+// and reads and executes debugger commands until 'quit' is entered or the
+// input ends.
+func (d *Debugger) Run() error {
+    if err := d.VM.Reset(); err != nil {
+        return err
+    }
+    d.VM.Interpreter.Breakpoints = make(map[int32]bool)
+    fmt.Fprintf(d.Out, "Debugging %s (%d instructions). Type 'help' for a list of commands.\n",d.VM.File,d.VM.VMem.CodeSize)
+    d.Where()
+    for {
+        fmt.Fprint(d.Out, "(debug) ")
+        if !d.In.Scan() {
+            fmt.Fprintln(d.Out)
+            return nil
+        }
+        parts := strings.Fields(d.In.Text())
+        if len(parts) == 0 {
+            continue
+        }
+        if parts[0] == "quit" || parts[0] == "q" {
+            return nil
+        }
+        if err := d.Command(parts[0], parts[1:]); err != nil {
+            fmt.Fprintf(d.Out, "%v\n",err)
+        }
+    }
+}
+
+// Command executes a single debugger command with its arguments. Errors in
+// the command are returned; errors in the program are reported to Out.
+func (d *Debugger) Command(command string, args []string) error {
+    switch command {
+    case "step", "s":
+        count := 1
+        if len(args) > 0 {
+            n, err := strconv.Atoi(args[0])
+            if err != nil || n < 1 {
+                return fmt.Errorf("gvm: debug: invalid step count '%s'",args[0])
+            }
+            count = n
+        }
+        if d.Finished() {
+            d.Stopped(nil)
+            return nil
+        }
+        // like continue, stop at a breakpoint after the first instruction
+        interp := d.VM.Interpreter
+        last := interp.Steps + int64(count)
+        d.Stopped(interp.RunUntil(func() bool {
+            return interp.Steps >= last
+        }))
+
+    case "next", "n":
+        if d.Finished() {
+            d.Stopped(nil)
+            return nil
+        }
+        d.Stopped(d.VM.Interpreter.StepOver())
+
+    case "continue", "c":
+        if d.Finished() {
+            d.Stopped(nil)
+            return nil
+        }
+        d.Stopped(d.VM.Interpreter.Interpret())
+
+    case "break", "b":
+        if len(args) == 0 {
+            d.Breakpoints()
+            return nil
+        }
+        addr, err := d.Address(args[0])
+        if err != nil {
+            return err
+        }
+        d.VM.Interpreter.Breakpoints[addr] = true
+        fmt.Fprintf(d.Out, "breakpoint at %s\n",d.Line(addr))
+
+    case "delete", "d":
+        if len(args) == 0 {
+            d.VM.Interpreter.Breakpoints = make(map[int32]bool)
+            fmt.Fprintln(d.Out, "deleted all breakpoints")
+            return nil
+        }
+        addr, err := d.Address(args[0])
+        if err != nil {
+            return err
+        }
+        if !d.VM.Interpreter.Breakpoints[addr] {
+            return fmt.Errorf("gvm: debug: no breakpoint at addr %d",addr)
+        }
+        delete(d.VM.Interpreter.Breakpoints, addr)
+        fmt.Fprintf(d.Out, "deleted breakpoint at addr %d\n",addr)
+
+    case "regs", "r":
+        d.Registers()
+
+    case "mem", "m":
+        addr, count := 0, MEM_SIZE
+        var err error
+        if len(args) > 0 {
+            if addr, err = d.Integer(args[0]); err != nil {
+                return err
+            }
+        }
+        if len(args) > 1 {
+            if count, err = d.Integer(args[1]); err != nil {
+                return err
+            }
+        }
+        return d.Memory(addr, count)
+
+    case "list", "l":
+        addr := d.VM.Interpreter.PC
+        if len(args) > 0 {
+            var err error
+            if addr, err = d.Address(args[0]); err != nil {
+                return err
+            }
+        }
+        d.List(addr, d.Line)
+
+    case "disasm", "x":
+        addr := d.VM.Interpreter.PC
+        if len(args) > 0 {
+            var err error
+            if addr, err = d.Address(args[0]); err != nil {
+                return err
+            }
+        }
+        d.List(addr, d.Disassembly)
+
+    case "set":
+        if len(args) != 2 {
+            return fmt.Errorf("gvm: debug: usage: set rN value")
+        }
+        register, err := strconv.Atoi(args[0][1:])
+        if err != nil || (args[0][0] != 'r' && args[0][0] != 'R') || register < 0 {
+            return fmt.Errorf("gvm: debug: invalid register '%s'",args[0])
+        }
+        value, err := interpreter.ParseInteger(args[1])
+        if err != nil {
+            return fmt.Errorf("gvm: debug: invalid value '%s'",args[1])
+        }
+        if err := d.VM.Interpreter.WriteTo(int32(register), value); err != nil {
+            return err
+        }
+        fmt.Fprintf(d.Out, "R%d: %d\n",register,value)
+
+    case "help", "h":
+        fmt.Fprint(d.Out, HELP)
+
+    default:
+        return fmt.Errorf("gvm: debug: unknown command '%s' (type 'help' for a list of commands)",command)
+    }
+    return nil
+}
+
+// HELP is the text printed by the help command.
+const HELP = `step [n]             execute the next n instructions (default 1), or until a breakpoint
+next                 execute the next instruction, stepping over CALLs
+continue             run until a breakpoint or the end of the program
+break [addr|label]   set a breakpoint, or list the breakpoints
+delete [addr|label]  delete a breakpoint, or every breakpoint
+regs                 print the PC, registers, SP and status flags
+mem [addr [count]]   print count words of data memory (default 0 8)
+list [addr|label]    list the instructions around addr (default PC)
+disasm [addr|label]  list the disassembled instructions around addr
+set rN value         write value to register rN
+quit                 leave the debugger
+`
+
+// Finished reports whether the program has ended.
+func (d *Debugger) Finished() bool {
+    return d.VM.Interpreter.Finished()
+}
+
+// Stopped reports why execution stopped: at a breakpoint, with an error,
+// or at the end of the program. Otherwise the next instruction is shown.
+func (d *Debugger) Stopped(err error) {
+    var breakErr *interpreter.BreakError
+    switch {
+    case errors.As(err, &breakErr):
+        fmt.Fprintf(d.Out, "breakpoint at addr %d\n",breakErr.PC)
+        d.Where()
+    case err != nil:
+        fmt.Fprintf(d.Out, "%v\n",d.VM.RuntimeError(err))
+    case d.VM.Interpreter.Halted:
+        fmt.Fprintf(d.Out, "program halted at addr %d with exit status %d\n",d.VM.Interpreter.PC,d.VM.Interpreter.ExitStatus)
+    case d.Finished():
+        fmt.Fprintln(d.Out, "program finished")
+    default:
+        d.Where()
+    }
+}
+
+// Where prints the next instruction to be executed.
+func (d *Debugger) Where() {
+    if d.Finished() {
+        return
+    }
+    fmt.Fprintf(d.Out, "=> %s\n",d.Line(d.VM.Interpreter.PC))
+}
+
+// Line formats the instruction at addr with its source line.
+func (d *Debugger) Line(addr int32) string {
+    sourceMap := d.VM.VMem.SourceMap
+    if addr < 0 || int(addr) >= len(sourceMap) {
+        return fmt.Sprintf("%d:",addr)
+    }
+    line := sourceMap[addr].Line
+    source := ""
+    if line > 0 && line <= len(d.VM.Source) {
+        source = strings.TrimSpace(d.VM.Source[line - 1])
+    }
+    return fmt.Sprintf("%d: %s",addr,source)
+}
+
+// Disassembly formats the disassembled instruction at addr with its 
+// label, if it has one. 
+func (d *Debugger) Disassembly(addr int32) string {
+    if name, ok := d.VM.VMem.Symbols[addr]; ok {
+        return fmt.Sprintf("%d: %s: %s",addr,name,d.VM.Instruction(addr))
+    }
+    return fmt.Sprintf("%d: %s",addr,d.VM.Instruction(addr))
+}
+
+// Address converts a breakpoint or list argument, either an address or a
+// label, into a code block address.
+func (d *Debugger) Address(arg string) (int32, error) {
+    addr, ok := d.VM.VMem.Labels[arg]
+    if !ok {
+        value, err := interpreter.ParseInteger(arg)
+        if err != nil {
+            return 0, fmt.Errorf("gvm: debug: '%s' is not an address or a label",arg)
+        }
+        addr = value
+    }
+    if addr < 0 || int(addr) >= d.VM.VMem.CodeSize {
+        return 0, fmt.Errorf("gvm: debug: addr %d is outside of the program [addr 0:%d]",addr,d.VM.VMem.CodeSize - 1)
+    }
+    return addr, nil
+}
+
+// Integer converts a mem argument into an int.
+func (d *Debugger) Integer(arg string) (int, error) {
+    value, err := interpreter.ParseInteger(arg)
+    if err != nil {
+        return 0, fmt.Errorf("gvm: debug: invalid integer '%s'",arg)
+    }
+    return int(value), nil
+}
+
+// Breakpoints lists the breakpoints in address order.
+func (d *Debugger) Breakpoints() {
+    var addrs []int
+    for addr := range d.VM.Interpreter.Breakpoints {
+        addrs = append(addrs, int(addr))
+    }
+    if len(addrs) == 0 {
+        fmt.Fprintln(d.Out, "no breakpoints")
+        return
+    }
+    sort.Ints(addrs)
+    for _, addr := range addrs {
+        fmt.Fprintf(d.Out, "breakpoint at %s\n",d.Line(int32(addr)))
+    }
+}
+
+// Registers prints the PC, the registers, the stack pointer and the
+// status flags set by the last CMP.
+func (d *Debugger) Registers() {
+    state := d.VM.Interpreter.State()
+    fmt.Fprintf(d.Out, "PC: %d\n",state.PC)
+    for i, value := range state.Registers {
+        fmt.Fprintf(d.Out, "R%d: %d\n",i,value)
+    }
+    fmt.Fprintf(d.Out, "SP: %d\n",state.SP)
+    var flags []string
+    if state.Flags & interpreter.FLAG_ZERO != 0 {
+        flags = append(flags, "ZERO")
+    }
+    if state.Flags & interpreter.FLAG_NEGATIVE != 0 {
+        flags = append(flags, "NEGATIVE")
+    }
+    fmt.Fprintf(d.Out, "FLAGS: %s\n",strings.Join(flags, " "))
+}
+
+// Memory prints count words of data memory starting at addr.
+func (d *Debugger) Memory(addr, count int) error {
+    data := d.VM.VMem.Data
+    if addr < 0 || addr >= len(data) || count < 1 {
+        return fmt.Errorf("gvm: debug: addr %d invalid [data memory is addr 0:%d]",addr,len(data) - 1)
+    }
+    for i := addr; i < addr + count && i < len(data); i++ {
+        fmt.Fprintf(d.Out, "M[%d]: %d\n",i,data[i])
+    }
+    return nil
+}
+
+// List prints LIST_SIZE instructions around addr, each formatted by line.
+// The next instruction is marked with '=>' and breakpoints with '*', e.g.
+// '*>' for a breakpoint at the next instruction.
+func (d *Debugger) List(addr int32, line func(int32) string) {
+    start := int(addr) - LIST_SIZE / 2
+    if start < 0 {
+        start = 0
+    }
+    for i := start; i < start + LIST_SIZE && i < d.VM.VMem.CodeSize; i++ {
+        marker := "  "
+        if int32(i) == d.VM.Interpreter.PC {
+            marker = "=>"
+        }
+        if d.VM.Interpreter.Breakpoints[int32(i)] {
+            marker = "*" + marker[1:]
+        }
+        fmt.Fprintf(d.Out, "%s %s\n",marker,line(int32(i)))
+    }
+}
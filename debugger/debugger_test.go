@@ -0,0 +1,91 @@
+package debugger
+
+import (
+    "bufio"
+    "strings"
+    "testing"
+    "gvm/vm"
+)
+
+const program = `      LDI r1, 2
+loop: CALL double
+      SUBI r2, 1
+      STDOUT r1
+      JUMP done
+double:
+      ADD r1, r1
+      RET
+done: HALT r1
+`
+
+// debug runs the debugger on program with the given commands, one per
+// line, and returns everything written by the debugger and the program.
+func debug(t *testing.T, commands string) (*Debugger, string) {
+    var output strings.Builder
+    virtualMachine := vm.NewVirtualMachine(&output)
+    if err := virtualMachine.Load(strings.NewReader(program)); err != nil {
+        t.Fatalf("FAIL: error returned from valid program: %v", err)
+    }
+    d := New(virtualMachine, bufio.NewScanner(strings.NewReader(commands)), &output)
+    if err := d.Run(); err != nil {
+        t.Fatalf("FAIL: error returned from debugger: %v", err)
+    }
+    return d, output.String()
+}
+
+// Tests that each command produces the expected output and leaves the
+// program in the expected state.
+func TestCommands(t *testing.T) {
+    testCases := []struct {
+        commands string
+        pc int32
+        contains []string
+    }{
+        {"step\nstep", 5, []string{"=> 1: loop: CALL double", "=> 5: ADD r1, r1"}},
+        {"step 3", 6, []string{"=> 6: RET"}},
+        {"break 5\nstep 3\nstep 2", 2, []string{"breakpoint at addr 5\n=> 5: ADD r1, r1", "=> 2: SUBI r2, 1"}},
+        {"next\nnext", 2, []string{"=> 2: SUBI r2, 1"}},
+        {"break double\ncontinue\nregs", 5, []string{"breakpoint at 5: ADD r1, r1", "breakpoint at addr 5", "PC: 5", "R1: 2"}},
+        {"break 3\ncontinue\nnext", 4, []string{"4\n", "=> 4: JUMP done"}},
+        {"break 3\nbreak loop\nbreak\ndelete 3\nbreak", 0, []string{"breakpoint at 1: loop: CALL double\nbreakpoint at 3: STDOUT r1\n", "deleted breakpoint at addr 3"}},
+        {"break 3\ndelete\ncontinue", 7, []string{"deleted all breakpoints", "program halted at addr 7 with exit status 4"}},
+        {"continue\nstep\ncontinue", 7, []string{"program halted at addr 7 with exit status 4\n(debug) program halted"}},
+        {"step\nset r1 -3\nstep 2\nregs\nset r0 1\nset r10 1\nset x1 1", 6, []string{"R1: -3", "R1: -6", "R0 is read-only", "invalid register: R10", "invalid register 'x1'"}},
+        {"set r2\nset r2, 0\nstep 3\ncmp", 6, []string{"usage: set rN value", "invalid register 'r2,'", "unknown command 'cmp'"}},
+        {"next\nnext\nlist", 2, []string{"   1: loop: CALL double\n=> 2: SUBI r2, 1\n   3: STDOUT r1"}},
+        {"break 6\nlist 6", 0, []string{"=> 0: LDI r1, 2", "*  6: RET"}},
+        {"step\ndisasm", 1, []string{"   0: LDI r1, 2\n=> 1: loop: CALL double\n   2: SUBI r2, 1", "   4: JUMP done\n   5: double: ADD r1, r1", "   7: done: HALT r1\n"}},
+        {"mem 1022\nmem 1024\nbreak 9\nbreak end\nstep x", 0, []string{"M[1022]: 0\nM[1023]: 0\n(debug)", "addr 1024 invalid", "addr 9 is outside of the program", "'end' is not an address or a label", "invalid step count 'x'"}},
+        {"step\nquit\nstep", 1, []string{"=> 1: loop: CALL double\n(debug) "}},
+    }
+
+    for _, testCase := range testCases {
+        d, output := debug(t, testCase.commands)
+        if d.VM.Interpreter.PC != testCase.pc {
+            t.Errorf("FAIL: %q: expected PC %d, got %d", testCase.commands, testCase.pc, d.VM.Interpreter.PC)
+        }
+        for _, text := range testCase.contains {
+            if !strings.Contains(output, text) {
+                t.Errorf("FAIL: %q: expected output to contain %q, got:\n%s", testCase.commands, text, output)
+            }
+        }
+    }
+}
+
+// Tests that runtime errors are reported with their source position and
+// leave the PC at the faulting instruction.
+func TestRuntimeError(t *testing.T) {
+    var output strings.Builder
+    virtualMachine := vm.NewVirtualMachine(&output)
+    virtualMachine.File = "divide"
+    if err := virtualMachine.Load(strings.NewReader("LDI r1, 1\nDIV r1, r2\n")); err != nil {
+        t.Fatalf("FAIL: error returned from valid program: %v", err)
+    }
+    d := New(virtualMachine, bufio.NewScanner(strings.NewReader("continue\nregs")), &output)
+    if err := d.Run(); err != nil {
+        t.Fatalf("FAIL: error returned from debugger: %v", err)
+    }
+    if !strings.Contains(output.String(), "gvm: divide:2:1: DIV at addr 1: divide by zero") || d.VM.Interpreter.PC != 1 {
+        t.Errorf("FAIL: expected divide by zero at addr 1, got PC %d:\n%s", d.VM.Interpreter.PC, output.String())
+    }
+}
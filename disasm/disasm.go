@@ -0,0 +1,157 @@
+// Package disasm turns the bytecode instructions generated by the 'parser'
+// package back into Susan source code. Each opcode is written using its
+// mnemonic and the form of its operands, as given by the opcode table of
+// the 'instructions' package, e.g., {0x01,1,8} is disassembled as 
+// 'LDI r1, 8'.
+//
+// When a program is disassembled, a label is written before every
+// instruction which is the target of a JUMP, conditional jump or CALL,
+// and the targets are written as labels. The program's own labels are
+// used when they are known; otherwise labels are named after their
+// address (e.g., addr12). Each instruction is followed by a comment
+// holding its address, so the output can be assembled again.
+package disasm
+
+import (
+    "fmt"
+    "io"
+    "strings"
+    "gvm/instructions"
+)
+
+// Shapes maps the shape numbers used by DRAW and BLINK to their names.
+var Shapes = map[int32]string{1: "heart", 2: "bird"}
+
+// Instruction disassembles a single bytecode instruction, e.g., 'LDI r1, 8'.
+// strs is the program's string table, used by PRINT, and symbols names
+// the addresses used as jump targets; targets without a name are written
+// as addresses. An error is returned if the opcode or an operand is
+// invalid.
+func Instruction(instr instructions.Instruction, strs []string, symbols map[int32]string) (string, error) {
+    opcode, ok := instructions.Opcodes[instr.GetOpCode()]
+    if !ok {
+        return "", fmt.Errorf("gvm: disasm: invalid opcode %#04x",instr.GetOpCode())
+    }
+    arg1, arg2 := instr.GetArg1(), instr.GetArg2()
+    operands := ""
+    switch opcode.Form {
+    case instructions.REG:
+        operands = fmt.Sprintf("r%d",arg1)
+    case instructions.REG_REG:
+        operands = fmt.Sprintf("r%d, r%d",arg1,arg2)
+    case instructions.REG_IMM:
+        operands = fmt.Sprintf("r%d, %d",arg1,arg2)
+    case instructions.ADDR:
+        if name, ok := symbols[arg1]; ok {
+            operands = name
+        } else {
+            operands = fmt.Sprintf("%d",arg1)
+        }
+    case instructions.SHAPE:
+        name, ok := Shapes[arg1]
+        if !ok {
+            return "", fmt.Errorf("gvm: disasm: %s: invalid shape %d",opcode.Mnemonic,arg1)
+        }
+        operands = "$" + name
+    case instructions.STRING:
+        if arg1 < 0 || int(arg1) >= len(strs) {
+            return "", fmt.Errorf("gvm: disasm: PRINT: invalid string index %d",arg1)
+        }
+        operands = Quote(strs[arg1])
+    case instructions.LOAD:
+        operands = fmt.Sprintf("r%d, [r%d]",arg1,arg2)
+    case instructions.LOAD_ADDR:
+        operands = fmt.Sprintf("r%d, [%d]",arg1,arg2)
+    case instructions.STORE:
+        operands = fmt.Sprintf("[r%d], r%d",arg1,arg2)
+    case instructions.STORE_ADDR:
+        operands = fmt.Sprintf("[%d], r%d",arg1,arg2)
+    }
+    if operands == "" {
+        return opcode.Mnemonic, nil
+    }
+    return opcode.Mnemonic + " " + operands, nil
+}
+
+// Quote writes s as a Susan string literal, using the escape sequences
+// \n, \t, \r, \0, \\ and \" understood by the lexer.
+func Quote(s string) string {
+    var builder strings.Builder
+    builder.WriteByte('"')
+    for i := 0; i < len(s); i++ {
+        switch s[i] {
+        case '\n':
+            builder.WriteString(`\n`)
+        case '\t':
+            builder.WriteString(`\t`)
+        case '\r':
+            builder.WriteString(`\r`)
+        case 0:
+            builder.WriteString(`\0`)
+        case '\\':
+            builder.WriteString(`\\`)
+        case '"':
+            builder.WriteString(`\"`)
+        default:
+            builder.WriteByte(s[i])
+        }
+    }
+    builder.WriteByte('"')
+    return builder.String()
+}
+
+// Symbols names the addresses in code which are labelled in the program
+// (labels, which may be nil) or are the target of a JUMP, conditional
+// jump or CALL. If an address has more than one label, the first in
+// alphabetical order is used. Targets without a label are named after
+// their address, e.g., addr12.
+func Symbols(code []instructions.Instruction, labels map[string]int32) map[int32]string {
+    symbols := make(map[int32]string)
+    for name, addr := range labels {
+        if prev, ok := symbols[addr]; !ok || name < prev {
+            symbols[addr] = name
+        }
+    }
+    for _, instr := range code {
+        opcode, ok := instructions.Opcodes[instr.GetOpCode()]
+        if !ok || opcode.Form != instructions.ADDR {
+            continue
+        }
+        target := instr.GetArg1()
+        if _, ok := symbols[target]; ok || target < 0 || int(target) > len(code) {
+            continue
+        }
+        name := fmt.Sprintf("addr%d",target)
+        for {
+            if _, taken := labels[name]; !taken {
+                break
+            }
+            name = "_" + name
+        }
+        symbols[target] = name
+    }
+    return symbols
+}
+
+// Disassemble writes the program held in code, with string table strs and
+// labels (which may be nil), to w as Susan source code. Each label is
+// written on its own line before the instruction it labels, followed by
+// the instruction and a comment holding its address.
+func Disassemble(w io.Writer, code []instructions.Instruction, strs []string, labels map[string]int32) error {
+    symbols := Symbols(code, labels)
+    for addr, instr := range code {
+        text, err := Instruction(instr, strs, symbols)
+        if err != nil {
+            return fmt.Errorf("%v at addr %d",err,addr)
+        }
+        if name, ok := symbols[int32(addr)]; ok {
+            fmt.Fprintf(w, "%s:\n",name)
+        }
+        fmt.Fprintf(w, "       %-24s ; %d\n",text,addr)
+    }
+    // a label after the last instruction: jumping to it ends the program
+    if name, ok := symbols[int32(len(code))]; ok {
+        fmt.Fprintf(w, "%s:\n",name)
+    }
+    return nil
+}
@@ -0,0 +1,150 @@
+package disasm
+
+import (
+    "os"
+    "bytes"
+    "strings"
+    "testing"
+    "path/filepath"
+    "gvm/parser"
+    "gvm/instructions"
+)
+
+// Tests that single instructions are disassembled into Susan source code.
+func TestInstruction(t *testing.T) {
+    testCases := []struct {
+        input string
+        expected string
+    }{
+        {"LDI r1, 8", "LDI r1, 8"},
+        {"LDI R2,-0x10", "LDI r2, -16"},
+        {"ADD r1,r2", "ADD r1, r2"},
+        {"ADDV r3, r4", "ADDV r3, r4"},
+        {"DRAW $heart", "DRAW $heart"},
+        {"BLINK $bird", "BLINK $bird"},
+        {"STDOUT r1", "STDOUT r1"},
+        {"PRINTR", "PRINTR"},
+        {"SHL r1, r2", "SHL r1, r2"},
+        {"SAR r1, 3", "SAR r1, 3"},
+        {"LD r1, [r2]", "LD r1, [r2]"},
+        {"LD r1, [8]", "LD r1, [8]"},
+        {"ST [r2], r1", "ST [r2], r1"},
+        {"ST [8], r1", "ST [8], r1"},
+        {"HALT", "HALT"},
+        {"HALT r3", "HALT r3"},
+        {"RET", "RET"},
+        {"JUMP 0", "JUMP 0"},
+        {"CALL 0", "CALL 0"},
+        {"PRINT \"say \\\"hi\\\"\\t\\\\\\n\"", "PRINT \"say \\\"hi\\\"\\t\\\\\\n\""},
+        {"PUTC r5", "PUTC r5"},
+        {"PRINTX r6", "PRINTX r6"},
+    }
+
+    for _, testCase := range testCases {
+        code, strs, _, err := parser.Assemble(strings.NewReader(testCase.input))
+        if err != nil || len(code) != 1 {
+            t.Fatalf("FAIL: %s: error returned from valid instruction: %v", testCase.input, err)
+        }
+        text, err := Instruction(code[0], strs, nil)
+        if err != nil || text != testCase.expected {
+            t.Errorf("FAIL: %s: expected %q, got %q: %v", testCase.input, testCase.expected, text, err)
+        }
+    }
+}
+
+// Tests that invalid instructions are reported as errors.
+func TestInvalid(t *testing.T) {
+    testCases := []instructions.Instruction{
+        instructions.NewNullaryInstruction(0x99),
+        instructions.NewUnaryInstruction(0x19, 3),
+        instructions.NewUnaryInstruction(0x4F, 1),
+    }
+    for _, instr := range testCases {
+        if text, err := Instruction(instr, []string{"a"}, nil); err == nil {
+            t.Errorf("FAIL: %v: expected an error, got %q", instr, text)
+        }
+    }
+}
+
+// Tests that jump targets are given labels, using the program's labels
+// when they are known.
+func TestDisassemble(t *testing.T) {
+    source := "LDI r1, 3\nloop: SUBI r1, 1\nCALL show\nCMP r1, r0\nJNE loop\nJUMP end\nshow: STDOUT r1\nRET\nend:"
+    code, strs, labels, err := parser.Assemble(strings.NewReader(source))
+    if err != nil {
+        t.Fatalf("FAIL: error returned from valid program: %v", err)
+    }
+    expected := "       LDI r1, 3                ; 0\n" +
+        "loop:\n" +
+        "       SUBI r1, 1               ; 1\n" +
+        "       CALL show                ; 2\n" +
+        "       CMP r1, r0               ; 3\n" +
+        "       JNE loop                 ; 4\n" +
+        "       JUMP end                 ; 5\n" +
+        "show:\n" +
+        "       STDOUT r1                ; 6\n" +
+        "       RET                      ; 7\n" +
+        "end:\n"
+    var builder strings.Builder
+    if err := Disassemble(&builder, code, strs, labels); err != nil || builder.String() != expected {
+        t.Errorf("FAIL: expected:\n%s\ngot:\n%s%v", expected, builder.String(), err)
+    }
+
+    // without the program's labels, labels are named after their address
+    builder.Reset()
+    if err := Disassemble(&builder, code, strs, nil); err != nil {
+        t.Fatalf("FAIL: error returned from valid program: %v", err)
+    }
+    for _, text := range []string{"addr1:\n       SUBI r1, 1", "CALL addr6", "JNE addr1", "JUMP addr8", "addr6:\n       STDOUT r1", "; 7\naddr8:\n"} {
+        if !strings.Contains(builder.String(), text) {
+            t.Errorf("FAIL: expected disassembly to contain %q, got:\n%s", text, builder.String())
+        }
+    }
+
+    // generated labels do not clash with the program's labels
+    symbols := Symbols(code, map[string]int32{"addr6": 0})
+    if symbols[0] != "addr6" || symbols[6] != "_addr6" {
+        t.Errorf("FAIL: expected labels addr6 and _addr6, got %v", symbols)
+    }
+}
+
+// Tests that every valid sample program assembles to the same bytecode
+// after it is disassembled, with and without its labels.
+func TestRoundTrip(t *testing.T) {
+    files, _ := filepath.Glob("../vm/testdata/test*")
+    samples, _ := filepath.Glob("../sun/*")
+    files = append(files, samples...)
+    tested := 0
+    for _, file := range files {
+        source, err := os.ReadFile(file)
+        if err != nil || strings.HasSuffix(file, ".md") {
+            continue
+        }
+        code, strs, labels, err := parser.Assemble(bytes.NewReader(source))
+        if err != nil {
+            continue // programs with syntax errors
+        }
+        for _, programLabels := range []map[string]int32{labels, nil} {
+            var builder strings.Builder
+            if err := Disassemble(&builder, code, strs, programLabels); err != nil {
+                t.Errorf("FAIL: %s: error returned from disassembly: %v", file, err)
+                continue
+            }
+            disassembled := builder.String()
+            code2, strs2, _, err := parser.Assemble(strings.NewReader(disassembled))
+            if err != nil || len(code2) != len(code) || strings.Join(strs2, "\x00") != strings.Join(strs, "\x00") {
+                t.Errorf("FAIL: %s: disassembly does not reassemble: %v\n%s", file, err, disassembled)
+                continue
+            }
+            for i := range code {
+                if code[i].String() != code2[i].String() {
+                    t.Errorf("FAIL: %s: addr %d: expected %v, got %v", file, i, code[i], code2[i])
+                }
+            }
+        }
+        tested++
+    }
+    if tested == 0 {
+        t.Errorf("FAIL: no sample programs found")
+    }
+}
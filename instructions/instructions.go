@@ -12,6 +12,14 @@
 //    and preserve the error type which occured during a failed instruction 
 //    execution 
 //
+// The opcodes of the instructions, with the mnemonic and operand form of
+// each, are defined in opcodes.go so that every package which reads or 
+// writes bytecode shares a single opcode table. 
+//
+// String prints an instruction as its raw opcode and arguments, e.g., 
+// {0x01,1,8} for LDI r1, 8. The 'disasm' package prints instructions as
+// Susan source code. 
+//
 // Type int32 values are used to be consistent with the registers of the ISA
 // being emulatd. 
 package instructions
@@ -54,7 +62,7 @@ func (bi *BinaryInstruction) GetArg2() int32 {
 }
 
 func (bi *BinaryInstruction) String() string {
-    return fmt.Sprintf("/%#02x,%d,%d}", bi.OpCode, bi.Arg1, bi.Arg2)
+    return fmt.Sprintf("{%#04x,%d,%d}", bi.OpCode, bi.Arg1, bi.Arg2)
 }
 
 // Unary Instructions: instructions with a single argument 
@@ -68,7 +76,7 @@ func NewUnaryInstruction(opcode,arg1 int32) Instruction {
 }
 
 func (ui *UnaryInstruction) String() string {
-    return fmt.Sprintf("/%#02x,%d}", ui.OpCode, ui.Arg1)
+    return fmt.Sprintf("{%#04x,%d}", ui.OpCode, ui.Arg1)
 }
 
 func (ui *UnaryInstruction) GetOpCode() int32 {
@@ -93,7 +101,7 @@ func NewNullaryInstruction(opcode int32) Instruction {
 }
 
 func (ni *NullaryInstruction) String() string {
-    return fmt.Sprintf("/%02x}", ni.OpCode)
+    return fmt.Sprintf("{%#04x}", ni.OpCode)
 }
 
 func (ni *NullaryInstruction) GetOpCode() int32 {
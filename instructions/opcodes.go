@@ -0,0 +1,145 @@
+package instructions
+
+// Opcodes of the bytecode instructions. The parser, interpreter, 
+// disassembler and object file encoding all use these values. 
+const (
+    OPCODE_STDOUT = 0x00
+    OPCODE_LDI    = 0x01
+    OPCODE_JUMP   = 0x02
+    OPCODE_ADD    = 0x17
+    OPCODE_ADDV   = 0x18
+    OPCODE_DRAW   = 0x19
+    OPCODE_BLINK  = 0x20
+    OPCODE_PRINTR = 0x21
+    OPCODE_SUB    = 0x22
+    OPCODE_MUL    = 0x23
+    OPCODE_DIV    = 0x24
+    OPCODE_MOD    = 0x25
+    OPCODE_NEG    = 0x26
+    OPCODE_CMP    = 0x27
+    OPCODE_JEQ    = 0x28
+    OPCODE_JNE    = 0x29
+    OPCODE_JLT    = 0x2A
+    OPCODE_JGT    = 0x2B
+    OPCODE_JLE    = 0x2C
+    OPCODE_JGE    = 0x2D
+    OPCODE_CALL   = 0x2E
+    OPCODE_RET    = 0x2F
+    OPCODE_LD     = 0x30 // LD Rd,[Rr]
+    OPCODE_LDA    = 0x31 // LD Rd,[K]
+    OPCODE_ST     = 0x32 // ST [Rd],Rr
+    OPCODE_STA    = 0x33 // ST [K],Rr
+    OPCODE_PUSH   = 0x34
+    OPCODE_POP    = 0x35
+    OPCODE_AND    = 0x36
+    OPCODE_OR     = 0x37
+    OPCODE_XOR    = 0x38
+    OPCODE_NOT    = 0x39
+    OPCODE_SHL    = 0x3A // SHL Rd,Rr
+    OPCODE_SHR    = 0x3B // SHR Rd,Rr
+    OPCODE_SAR    = 0x3C // SAR Rd,Rr
+    OPCODE_SHLI   = 0x3D // SHL Rd,K
+    OPCODE_SHRI   = 0x3E // SHR Rd,K
+    OPCODE_SARI   = 0x3F // SAR Rd,K
+    OPCODE_MOV    = 0x40
+    OPCODE_ADDI   = 0x41
+    OPCODE_SUBI   = 0x42
+    OPCODE_MULI   = 0x43
+    OPCODE_DIVI   = 0x44
+    OPCODE_MODI   = 0x45
+    OPCODE_ANDI   = 0x46
+    OPCODE_ORI    = 0x47
+    OPCODE_XORI   = 0x48
+    OPCODE_HALT   = 0x49 // HALT
+    OPCODE_HALTR  = 0x4A // HALT Rr
+    OPCODE_NOP    = 0x4B
+    OPCODE_STDIN  = 0x4C
+    OPCODE_GETC   = 0x4D
+    OPCODE_PUTC   = 0x4E
+    OPCODE_PRINT  = 0x4F // PRINT "string"
+    OPCODE_PRINTD = 0x50
+    OPCODE_PRINTX = 0x51
+    OPCODE_PRINTB = 0x52
+)
+
+// Operand forms
+const (
+    NONE    = iota // e.g. RET
+    REG            // e.g. PUSH r1
+    REG_REG        // e.g. ADD r1, r2
+    REG_IMM        // e.g. ADDI r1, 8
+    ADDR           // e.g. JUMP loop
+    SHAPE          // e.g. DRAW $heart
+    STRING         // e.g. PRINT "hello"
+    LOAD           // e.g. LD r1, [r2]
+    LOAD_ADDR      // e.g. LD r1, [8]
+    STORE          // e.g. ST [r2], r1
+    STORE_ADDR     // e.g. ST [8], r1
+)
+
+// Opcode describes how an instruction is written in Susan source code.
+type Opcode struct {
+    Mnemonic string
+    Form int
+}
+
+// Opcodes maps each opcode to its mnemonic and the form of its operands.
+var Opcodes = map[int32]Opcode{
+    OPCODE_STDOUT: {"STDOUT", REG},
+    OPCODE_LDI:    {"LDI", REG_IMM},
+    OPCODE_JUMP:   {"JUMP", ADDR},
+    OPCODE_ADD:    {"ADD", REG_REG},
+    OPCODE_ADDV:   {"ADDV", REG_REG},
+    OPCODE_DRAW:   {"DRAW", SHAPE},
+    OPCODE_BLINK:  {"BLINK", SHAPE},
+    OPCODE_PRINTR: {"PRINTR", NONE},
+    OPCODE_SUB:    {"SUB", REG_REG},
+    OPCODE_MUL:    {"MUL", REG_REG},
+    OPCODE_DIV:    {"DIV", REG_REG},
+    OPCODE_MOD:    {"MOD", REG_REG},
+    OPCODE_NEG:    {"NEG", REG},
+    OPCODE_CMP:    {"CMP", REG_REG},
+    OPCODE_JEQ:    {"JEQ", ADDR},
+    OPCODE_JNE:    {"JNE", ADDR},
+    OPCODE_JLT:    {"JLT", ADDR},
+    OPCODE_JGT:    {"JGT", ADDR},
+    OPCODE_JLE:    {"JLE", ADDR},
+    OPCODE_JGE:    {"JGE", ADDR},
+    OPCODE_CALL:   {"CALL", ADDR},
+    OPCODE_RET:    {"RET", NONE},
+    OPCODE_LD:     {"LD", LOAD},
+    OPCODE_LDA:    {"LD", LOAD_ADDR},
+    OPCODE_ST:     {"ST", STORE},
+    OPCODE_STA:    {"ST", STORE_ADDR},
+    OPCODE_PUSH:   {"PUSH", REG},
+    OPCODE_POP:    {"POP", REG},
+    OPCODE_AND:    {"AND", REG_REG},
+    OPCODE_OR:     {"OR", REG_REG},
+    OPCODE_XOR:    {"XOR", REG_REG},
+    OPCODE_NOT:    {"NOT", REG},
+    OPCODE_SHL:    {"SHL", REG_REG},
+    OPCODE_SHR:    {"SHR", REG_REG},
+    OPCODE_SAR:    {"SAR", REG_REG},
+    OPCODE_SHLI:   {"SHL", REG_IMM},
+    OPCODE_SHRI:   {"SHR", REG_IMM},
+    OPCODE_SARI:   {"SAR", REG_IMM},
+    OPCODE_MOV:    {"MOV", REG_REG},
+    OPCODE_ADDI:   {"ADDI", REG_IMM},
+    OPCODE_SUBI:   {"SUBI", REG_IMM},
+    OPCODE_MULI:   {"MULI", REG_IMM},
+    OPCODE_DIVI:   {"DIVI", REG_IMM},
+    OPCODE_MODI:   {"MODI", REG_IMM},
+    OPCODE_ANDI:   {"ANDI", REG_IMM},
+    OPCODE_ORI:    {"ORI", REG_IMM},
+    OPCODE_XORI:   {"XORI", REG_IMM},
+    OPCODE_HALT:   {"HALT", NONE},
+    OPCODE_HALTR:  {"HALT", REG},
+    OPCODE_NOP:    {"NOP", NONE},
+    OPCODE_STDIN:  {"STDIN", REG},
+    OPCODE_GETC:   {"GETC", REG},
+    OPCODE_PUTC:   {"PUTC", REG},
+    OPCODE_PRINT:  {"PRINT", STRING},
+    OPCODE_PRINTD: {"PRINTD", REG},
+    OPCODE_PRINTX: {"PRINTX", REG},
+    OPCODE_PRINTB: {"PRINTB", REG},
+}
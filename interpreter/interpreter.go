@@ -7,38 +7,135 @@ package interpreter
 import (
     "fmt"
     "os"
+    "io"
+    "bufio"
+    "errors"
     "time"
     "strings"
+    "strconv"
+    "unicode"
     "gvm/instructions"
     "github.com/fatih/color"
 )
 
-// Defining OPCODES as constants  
+// MAX_STEPS is the default number of instructions a program may execute
+// before it is stopped with an execution limit exceeded error. 
+const MAX_STEPS = 10000000
+
+// EOF_VALUE is the value written to the destination register by STDIN 
+// and GETC when the input is exhausted. 
+const EOF_VALUE = -1
+
+// Status flags set by the CMP instruction and read by the conditional
+// jump instructions. 
 const (
-    OPCODE_STDOUT = 0x00
-    OPCODE_LDI    = 0x01
-    OPCODE_JUMP   = 0x02
-    OPCODE_ADD    = 0x17
-    OPCODE_ADDV   = 0x18
-    OPCODE_DRAW   = 0x19
-    OPCODE_BLINK  = 0x20
-    OPCODE_PRINTR = 0x21
+    FLAG_ZERO     = 1 << 0 // the compared values were equal
+    FLAG_NEGATIVE = 1 << 1 // the first value was less than the second
 )
 
 type Interpreter struct {
     PC int32
     Registers []int32
     Code []instructions.Instruction
+
+    // Strings is a reference to the program's string table which holds 
+    // the text printed by PRINT instructions. 
+    Strings []string
+
+    // Flags holds the status flags set by the last CMP instruction. 
+    Flags int32
+
+    // CallStack is a reference to the return stack allocated by the 'vm',
+    // and CallDepth is the number of return addresses currently on it. 
+    CallStack []int32
+    CallDepth int
+
+    // Data is a reference to the word-addressable data memory allocated by
+    // the 'vm' which is read and written by the LD and ST instructions. 
+    Data []int32
+
+    // SP is the hidden stack pointer register used by PUSH and POP. The 
+    // data stack occupies the top of the data memory and grows downwards
+    // from len(Data) (empty) to StackLimit (full), so SP holds the address
+    // of the top-of-stack word. 
+    SP int32
+    StackLimit int32
+
+    // Steps counts the instructions executed so far. MaxSteps (the 
+    // instruction budget) and Timeout (wall-clock time) protect the host
+    // from programs which never terminate. A value <= 0 disables the
+    // corresponding limit. 
+    Steps int64
+    MaxSteps int64
+    Timeout time.Duration
+
+    // Halted is set when a HALT instruction stops the program, and 
+    // ExitStatus holds the exit status given to HALT (0 by default). 
+    Halted bool
+    ExitStatus int32
+
+    // Input is the source read by the STDIN and GETC instructions. It 
+    // defaults to os.Stdin and may be replaced to feed a program its input
+    // from elsewhere (e.g., a string in tests). It is buffered by reader.
+    Input io.Reader
+    reader *bufio.Reader
+    readerSource io.Reader
+
+    // Breakpoints holds the addresses at which Interpret stops execution 
+    // for a debugger. 
+    Breakpoints map[int32]bool
+
+    // Output is the destination of everything the program prints (STDOUT,
+    // PRINTR, ADDV, DRAW, BLINK and the other output instructions). 
+    Output io.Writer
+
+    // Trace, if not nil, is called after each instruction is executed 
+    // with a snapshot of the state before the instruction, so a tracer can
+    // compare it with the current State. 
+    Trace func(before State)
+}
+
+// LimitError is returned by Interpret when a program exceeds its 
+// instruction budget or its timeout. It reports the address of the 
+// next instruction and the number of instructions executed. 
+type LimitError struct {
+    Limit string
+    PC int32
+    Steps int64
+}
+
+func (e *LimitError) Error() string {
+    return fmt.Sprintf("gvm: execution limit exceeded: %s at addr %d after %d steps",e.Limit,e.PC,e.Steps)
+}
+
+// BreakError is returned by Interpret when execution stops at a 
+// breakpoint. It reports the address of the breakpoint, which is the 
+// next instruction to be executed. 
+type BreakError struct {
+    PC int32
+}
+
+func (e *BreakError) Error() string {
+    return fmt.Sprintf("gvm: breakpoint at addr %d",e.PC)
 }
 
 // Initialze an interpreter with pre-allocated virtual memory provided by 
 // the 'vm': this includes 10 registers and a codeblock containing the
 // bytecode representation of the source program's asm instructions. 
-func New(vregisters []int32, code []instructions.Instruction) *Interpreter {
+// The instruction budget is set to MAX_STEPS and there is no timeout. 
+// Input is read from os.Stdin and program output is written to output,
+// or to os.Stdout if output is nil. 
+func New(vregisters []int32, code []instructions.Instruction, output io.Writer) *Interpreter {
+    if output == nil {
+        output = os.Stdout
+    }
     return &Interpreter{
         PC: 0,
         Registers: vregisters, 
         Code: code,
+        MaxSteps: MAX_STEPS,
+        Input: os.Stdin,
+        Output: output,
     }
 }
 
@@ -47,16 +144,144 @@ func New(vregisters []int32, code []instructions.Instruction) *Interpreter {
 // the source code is parsed as bytecode into the virtual memory codeblock, 
 // the VM writes the size of the codeblock into register 0 so the interpreter
 // knows the start and end addresses of the address space where the bytecode
-// is stored, i.e., where the interpreter has permission to access. 
+// is stored, i.e., where the interpreter has permission to access. The 
+// program ends when the PC reaches the end of the codeblock or when a HALT
+// instruction is executed. 
+//
+// Before each instruction, the instruction budget and timeout are checked
+// and a LimitError is returned if either has been exceeded. The clock is
+// only read every 1024 instructions to keep the check cheap. 
+//
+// Execution also stops with a BreakError before any instruction after the
+// first whose address is in Breakpoints, so calling Interpret again 
+// continues from a breakpoint. 
 func (interp *Interpreter) Interpret() error {
-    lastAddr,_ := interp.ReadFrom(0)
-    for interp.PC < lastAddr {
-        if err := interp.DecodeAndDispatch(interp.Code[interp.PC]); err != nil {
+    return interp.RunUntil(nil)
+}
+
+// RunUntil executes instructions as Interpret does, and also returns nil
+// before any instruction after the first for which stop, if not nil, 
+// returns true. 
+func (interp *Interpreter) RunUntil(stop func() bool) error {
+    start := time.Now()
+    for first := true; !interp.Finished(); first = false {
+        if !first && interp.Breakpoints[interp.PC] {
+            return &BreakError{PC: interp.PC}
+        }
+        if !first && stop != nil && stop() {
+            return nil
+        }
+        if interp.MaxSteps > 0 && interp.Steps >= interp.MaxSteps {
+            return &LimitError{Limit: fmt.Sprintf("step limit (%d)",interp.MaxSteps), PC: interp.PC, Steps: interp.Steps}
+        }
+        if interp.Timeout > 0 && interp.Steps % 1024 == 0 && time.Since(start) > interp.Timeout {
+            return &LimitError{Limit: fmt.Sprintf("timeout (%v)",interp.Timeout), PC: interp.PC, Steps: interp.Steps}
+        }
+        if _, err := interp.Step(); err != nil {
             return err
         }
+    }
+    return nil
+}
+
+// StepOver executes the instruction at the PC like Step, except that a 
+// CALL is executed together with the subroutine it calls: execution 
+// continues until the subroutine returns to the instruction following 
+// the CALL, unless the program ends or a breakpoint is reached first. 
+func (interp *Interpreter) StepOver() error {
+    if interp.Finished() || interp.Code[interp.PC].GetOpCode() != instructions.OPCODE_CALL {
+        _, err := interp.Step()
+        return err
+    }
+    returnAddr, depth := interp.PC + 1, interp.CallDepth
+    return interp.RunUntil(func() bool {
+        return interp.PC == returnAddr && interp.CallDepth == depth
+    })
+}
+
+// Finished reports whether the program has ended, either by running off
+// the end of the codeblock or by executing a HALT instruction. 
+func (interp *Interpreter) Finished() bool {
+    lastAddr,_ := interp.ReadFrom(0)
+    return interp.Halted || interp.PC >= lastAddr
+}
+
+// Step executes the single instruction at the PC and advances the PC, so
+// that tools can drive execution one instruction at a time. It reports 
+// whether the program has ended; once it has, Step does nothing. The 
+// instruction budget and timeout are not checked. If the instruction 
+// fails, the error is returned and the PC is left at the faulting 
+// instruction. 
+func (interp *Interpreter) Step() (halted bool, err error) {
+    if interp.Finished() {
+        return true, nil
+    }
+    var before State
+    if interp.Trace != nil {
+        before = interp.State()
+    }
+    if err := interp.DecodeAndDispatch(interp.Code[interp.PC]); err != nil {
+        return false, err
+    }
+    interp.Steps++
+    // the PC is left at the address of the HALT instruction
+    if !interp.Halted {
         interp.PC++
     }
-    return nil 
+    if interp.Trace != nil {
+        interp.Trace(before)
+    }
+    return interp.Finished(), nil
+}
+
+// Reset restores the initial state of the loaded program without 
+// re-parsing it: the PC, status flags, step count and exit status are 
+// cleared, registers R1:R9 and the data memory are zeroed, and the call
+// stack and data stack are emptied. R0, the code block and the input 
+// are left unchanged. 
+func (interp *Interpreter) Reset() {
+    interp.PC = 0
+    interp.Flags = 0
+    interp.Steps = 0
+    interp.Halted = false
+    interp.ExitStatus = 0
+    for i := 1; i < len(interp.Registers); i++ {
+        interp.Registers[i] = 0
+    }
+    for i := range interp.Data {
+        interp.Data[i] = 0
+    }
+    interp.CallDepth = 0
+    interp.SP = int32(len(interp.Data))
+}
+
+// State is a read-only snapshot of the interpreter's registers and 
+// execution state. 
+type State struct {
+    PC int32
+    Registers [10]int32
+    SP int32
+    Flags int32
+    CallDepth int
+    Steps int64
+    Halted bool
+    ExitStatus int32
+}
+
+// State returns a snapshot of the current registers and execution state.
+// Changing the snapshot does not affect the interpreter. 
+func (interp *Interpreter) State() State {
+    state := State{
+        PC: interp.PC,
+        SP: interp.SP,
+        Flags: interp.Flags,
+        CallDepth: interp.CallDepth,
+        Steps: interp.Steps,
+        Halted: interp.Halted,
+        ExitStatus: interp.ExitStatus,
+    }
+    copy(state.Registers[:], interp.Registers)
+    return state
 }
 
 
@@ -69,60 +294,272 @@ func (interp *Interpreter) DecodeAndDispatch(instr instructions.Instruction) err
     switch instr.GetOpCode() {
     
      // LDI
-    case OPCODE_LDI:
+    case instructions.OPCODE_LDI:
         if err := interp.LoadImmediate(instr.GetArg1(),instr.GetArg2()); err != nil {
             return err 
         }
         return nil
 
     // JUMP
-    case OPCODE_JUMP:
+    case instructions.OPCODE_JUMP:
         if err := interp.JumpTo(instr.GetArg1()); err != nil {
             return err
         }
         return nil
     
     // ADD
-    case OPCODE_ADD:
+    case instructions.OPCODE_ADD:
         if err := interp.Add(instr.GetArg1(),instr.GetArg2()); err != nil {
             return err
         }
         return nil
 
     // ADDV 
-    case OPCODE_ADDV:
+    case instructions.OPCODE_ADDV:
         if err := interp.AddV(instr.GetArg1(),instr.GetArg2()); err != nil {
             return err
         }
         return nil
 
     // DRAW
-    case OPCODE_DRAW:
+    case instructions.OPCODE_DRAW:
         if err := interp.Draw(instr.GetArg1()); err != nil {
             return err
         }
         return nil
 
     // BLINK
-    case OPCODE_BLINK:
+    case instructions.OPCODE_BLINK:
         if err := interp.Blink(instr.GetArg1()); err != nil {
             return err
         }
         return nil
         
     // STDOUT
-    case OPCODE_STDOUT:
+    case instructions.OPCODE_STDOUT:
         if err := interp.PrintToStdOut(instr.GetArg1()); err != nil {
             return err
         }
         return nil 
 
     // PRINTR
-    case OPCODE_PRINTR:
+    case instructions.OPCODE_PRINTR:
         if err := interp.PrintRegisters(); err != nil {
             return err
         }
         return nil
+
+    // SUB
+    case instructions.OPCODE_SUB:
+        if err := interp.Sub(instr.GetArg1(),instr.GetArg2()); err != nil {
+            return err
+        }
+        return nil
+
+    // MUL
+    case instructions.OPCODE_MUL:
+        if err := interp.Mul(instr.GetArg1(),instr.GetArg2()); err != nil {
+            return err
+        }
+        return nil
+
+    // DIV
+    case instructions.OPCODE_DIV:
+        if err := interp.Div(instr.GetArg1(),instr.GetArg2()); err != nil {
+            return err
+        }
+        return nil
+
+    // MOD
+    case instructions.OPCODE_MOD:
+        if err := interp.Mod(instr.GetArg1(),instr.GetArg2()); err != nil {
+            return err
+        }
+        return nil
+
+    // CMP
+    case instructions.OPCODE_CMP:
+        if err := interp.Compare(instr.GetArg1(),instr.GetArg2()); err != nil {
+            return err
+        }
+        return nil
+
+    // JEQ, JNE, JLT, JGT, JLE, JGE
+    case instructions.OPCODE_JEQ, instructions.OPCODE_JNE, instructions.OPCODE_JLT, instructions.OPCODE_JGT, instructions.OPCODE_JLE, instructions.OPCODE_JGE:
+        if err := interp.JumpIf(instr.GetOpCode(),instr.GetArg1()); err != nil {
+            return err
+        }
+        return nil
+
+    // CALL
+    case instructions.OPCODE_CALL:
+        if err := interp.Call(instr.GetArg1()); err != nil {
+            return err
+        }
+        return nil
+
+    // RET
+    case instructions.OPCODE_RET:
+        if err := interp.Return(); err != nil {
+            return err
+        }
+        return nil
+
+    // LD Rd,[Rr]
+    case instructions.OPCODE_LD:
+        addr, err := interp.ReadFrom(instr.GetArg2())
+        if err != nil {
+            return err
+        }
+        if err := interp.Load(instr.GetArg1(),addr); err != nil {
+            return err
+        }
+        return nil
+
+    // LD Rd,[K]
+    case instructions.OPCODE_LDA:
+        if err := interp.Load(instr.GetArg1(),instr.GetArg2()); err != nil {
+            return err
+        }
+        return nil
+
+    // ST [Rd],Rr
+    case instructions.OPCODE_ST:
+        addr, err := interp.ReadFrom(instr.GetArg1())
+        if err != nil {
+            return err
+        }
+        if err := interp.Store(addr,instr.GetArg2()); err != nil {
+            return err
+        }
+        return nil
+
+    // ST [K],Rr
+    case instructions.OPCODE_STA:
+        if err := interp.Store(instr.GetArg1(),instr.GetArg2()); err != nil {
+            return err
+        }
+        return nil
+
+    // PUSH
+    case instructions.OPCODE_PUSH:
+        if err := interp.Push(instr.GetArg1()); err != nil {
+            return err
+        }
+        return nil
+
+    // POP
+    case instructions.OPCODE_POP:
+        if err := interp.Pop(instr.GetArg1()); err != nil {
+            return err
+        }
+        return nil
+
+    // AND, OR, XOR
+    case instructions.OPCODE_AND, instructions.OPCODE_OR, instructions.OPCODE_XOR:
+        if err := interp.Bitwise(instr.GetOpCode(),instr.GetArg1(),instr.GetArg2()); err != nil {
+            return err
+        }
+        return nil
+
+    // NOT
+    case instructions.OPCODE_NOT:
+        if err := interp.Not(instr.GetArg1()); err != nil {
+            return err
+        }
+        return nil
+
+    // SHL, SHR, SAR with the shift count in a register 
+    case instructions.OPCODE_SHL, instructions.OPCODE_SHR, instructions.OPCODE_SAR:
+        count, err := interp.ReadFrom(instr.GetArg2())
+        if err != nil {
+            return err
+        }
+        if err := interp.Shift(instr.GetOpCode(),instr.GetArg1(),count); err != nil {
+            return err
+        }
+        return nil
+
+    // SHL, SHR, SAR with an immediate shift count 
+    case instructions.OPCODE_SHLI, instructions.OPCODE_SHRI, instructions.OPCODE_SARI:
+        if err := interp.Shift(instr.GetOpCode(),instr.GetArg1(),instr.GetArg2()); err != nil {
+            return err
+        }
+        return nil
+
+    // MOV
+    case instructions.OPCODE_MOV:
+        if err := interp.Move(instr.GetArg1(),instr.GetArg2()); err != nil {
+            return err
+        }
+        return nil
+
+    // ADDI, SUBI, MULI, DIVI, MODI, ANDI, ORI, XORI
+    case instructions.OPCODE_ADDI, instructions.OPCODE_SUBI, instructions.OPCODE_MULI, instructions.OPCODE_DIVI, instructions.OPCODE_MODI, instructions.OPCODE_ANDI, instructions.OPCODE_ORI, instructions.OPCODE_XORI:
+        if err := interp.Immediate(instr.GetOpCode(),instr.GetArg1(),instr.GetArg2()); err != nil {
+            return err
+        }
+        return nil
+
+    // HALT
+    case instructions.OPCODE_HALT:
+        interp.Halt(0)
+        return nil
+
+    // HALT Rr
+    case instructions.OPCODE_HALTR:
+        status, err := interp.ReadFrom(instr.GetArg1())
+        if err != nil {
+            return err
+        }
+        interp.Halt(status)
+        return nil
+
+    // NOP
+    case instructions.OPCODE_NOP:
+        return nil
+
+    // STDIN
+    case instructions.OPCODE_STDIN:
+        if err := interp.ReadInteger(instr.GetArg1()); err != nil {
+            return err
+        }
+        return nil
+
+    // GETC
+    case instructions.OPCODE_GETC:
+        if err := interp.ReadChar(instr.GetArg1()); err != nil {
+            return err
+        }
+        return nil
+
+    // PUTC
+    case instructions.OPCODE_PUTC:
+        if err := interp.PutChar(instr.GetArg1()); err != nil {
+            return err
+        }
+        return nil
+
+    // PRINT "string"
+    case instructions.OPCODE_PRINT:
+        if err := interp.PrintString(instr.GetArg1()); err != nil {
+            return err
+        }
+        return nil
+
+    // PRINTD, PRINTX, PRINTB
+    case instructions.OPCODE_PRINTD, instructions.OPCODE_PRINTX, instructions.OPCODE_PRINTB:
+        if err := interp.PrintFormatted(instr.GetOpCode(),instr.GetArg1()); err != nil {
+            return err
+        }
+        return nil
+
+    // NEG
+    case instructions.OPCODE_NEG:
+        if err := interp.Neg(instr.GetArg1()); err != nil {
+            return err
+        }
+        return nil
      
     // invalid opcode     
     default:
@@ -158,18 +595,18 @@ func (interp *Interpreter) ReadFrom(register int32) (int32, error) {
 // CheckJump validates the requested jump address provided as an argument to a 
 // JUMP instruction. 
 //
-// If the address provided is less than the current address, then an infinite loop
-// warning error is returned. 
+// Backward jumps are allowed; programs which never terminate are stopped by
+// the instruction budget and timeout checked in Interpret. 
 //
 // If the address provided is outside of the virtual memory codeblock, where the last 
 // address of the codeblock is provided in register 0, then a segmentation violation
-// error is returned as the JUMP address is not a valid memory address. 
+// error is returned as the JUMP address is not a valid memory address. The address
+// just past the last instruction (the value of register 0) is valid: it is where a
+// label on the last line of a program points, and jumping there ends the program as
+// if it had run off the end of the codeblock. 
 func (interp *Interpreter) CheckJump(addr int32) error {
     lastAddr,_ := interp.ReadFrom(0)
-    if addr <= interp.PC {
-        return fmt.Errorf("gvm: JUMP at addr %d to %d: infinite loop warning.",interp.PC,addr)
-    }
-    if addr > lastAddr - 1 {
+    if addr < 0 || addr > lastAddr {
         return fmt.Errorf("gvm: JUMP addr invalid: segmentation violation.")
     }
     return nil
@@ -218,19 +655,511 @@ func (interp *Interpreter) Add(ri, rj int32) error {
     return nil
 }
 
+// ReadPair reads the values in registers ri and rj for the binary 
+// arithmetic routines below. 
+func (interp *Interpreter) ReadPair(ri, rj int32) (int32, int32, error) {
+    value1, err := interp.ReadFrom(ri)
+    if err != nil {
+        return 0, 0, err
+    }
+    value2, err := interp.ReadFrom(rj)
+    if err != nil {
+        return 0, 0, err
+    }
+    return value1, value2, nil
+}
+
+// SUB routine: Sub
+// Sub reads the values in registers ri and rj, subtracts the value in
+// rj from the value in ri, and writes the result to register ri. 
+func (interp *Interpreter) Sub(ri, rj int32) error {
+    value1, value2, err := interp.ReadPair(ri, rj)
+    if err != nil {
+        return err
+    }
+    return interp.WriteTo(ri, value1 - value2)
+}
+
+// MUL routine: Mul
+// Mul reads the values in registers ri and rj, multiplies them, and 
+// writes the result to register ri. 
+func (interp *Interpreter) Mul(ri, rj int32) error {
+    value1, value2, err := interp.ReadPair(ri, rj)
+    if err != nil {
+        return err
+    }
+    return interp.WriteTo(ri, value1 * value2)
+}
+
+// DIV routine: Div
+// Div reads the values in registers ri and rj, divides the value in ri 
+// by the value in rj (truncating towards zero), and writes the quotient
+// to register ri. If rj holds 0, then a divide by zero error is returned
+// with the address of the faulting instruction. 
+func (interp *Interpreter) Div(ri, rj int32) error {
+    value1, value2, err := interp.ReadPair(ri, rj)
+    if err != nil {
+        return err
+    }
+    if value2 == 0 {
+        return fmt.Errorf("gvm: DIV at addr %d: divide by zero [R%d is 0]",interp.PC,rj)
+    }
+    return interp.WriteTo(ri, value1 / value2)
+}
+
+// MOD routine: Mod
+// Mod reads the values in registers ri and rj, and writes the remainder
+// of dividing the value in ri by the value in rj to register ri. The 
+// remainder has the sign of the value in ri. If rj holds 0, then a 
+// divide by zero error is returned with the address of the faulting
+// instruction. 
+func (interp *Interpreter) Mod(ri, rj int32) error {
+    value1, value2, err := interp.ReadPair(ri, rj)
+    if err != nil {
+        return err
+    }
+    if value2 == 0 {
+        return fmt.Errorf("gvm: MOD at addr %d: divide by zero [R%d is 0]",interp.PC,rj)
+    }
+    return interp.WriteTo(ri, value1 % value2)
+}
+
+// HALT routine: Halt
+// Halt stops the program with the given exit status once the current 
+// instruction completes. 
+func (interp *Interpreter) Halt(status int32) {
+    interp.Halted = true
+    interp.ExitStatus = status
+}
+
+// MOV routine: Move
+// Move copies the value in register rj to register ri. 
+func (interp *Interpreter) Move(ri, rj int32) error {
+    value, err := interp.ReadFrom(rj)
+    if err != nil {
+        return err
+    }
+    return interp.WriteTo(ri, value)
+}
+
+// ADDI, SUBI, MULI, DIVI, MODI, ANDI, ORI, XORI routine: Immediate
+// Immediate combines the value in register ri with the immediate value k
+// using the operation given by opcode, and writes the result to register
+// ri. The operations behave as their register-register counterparts; in
+// particular DIVI and MODI by 0 return a divide by zero error. 
+func (interp *Interpreter) Immediate(opcode, ri, k int32) error {
+    value, err := interp.ReadFrom(ri)
+    if err != nil {
+        return err
+    }
+    var result int32
+    switch opcode {
+    case instructions.OPCODE_ADDI:
+        result = value + k
+    case instructions.OPCODE_SUBI:
+        result = value - k
+    case instructions.OPCODE_MULI:
+        result = value * k
+    case instructions.OPCODE_DIVI:
+        if k == 0 {
+            return fmt.Errorf("gvm: DIVI at addr %d: divide by zero",interp.PC)
+        }
+        result = value / k
+    case instructions.OPCODE_MODI:
+        if k == 0 {
+            return fmt.Errorf("gvm: MODI at addr %d: divide by zero",interp.PC)
+        }
+        result = value % k
+    case instructions.OPCODE_ANDI:
+        result = value & k
+    case instructions.OPCODE_ORI:
+        result = value | k
+    case instructions.OPCODE_XORI:
+        result = value ^ k
+    default:
+        return fmt.Errorf("interpreter/interpreter.go: invalid immediate operation %#02x",opcode)
+    }
+    return interp.WriteTo(ri, result)
+}
+
+// NEG routine: Neg
+// Neg negates the value in register ri. 
+func (interp *Interpreter) Neg(ri int32) error {
+    value, err := interp.ReadFrom(ri)
+    if err != nil {
+        return err
+    }
+    return interp.WriteTo(ri, -value)
+}
+
+// AND, OR, XOR routine: Bitwise
+// Bitwise reads the values in registers ri and rj, combines them with the
+// bitwise operation given by opcode, and writes the result to register ri.
+func (interp *Interpreter) Bitwise(opcode, ri, rj int32) error {
+    value1, value2, err := interp.ReadPair(ri, rj)
+    if err != nil {
+        return err
+    }
+    switch opcode {
+    case instructions.OPCODE_AND:
+        return interp.WriteTo(ri, value1 & value2)
+    case instructions.OPCODE_OR:
+        return interp.WriteTo(ri, value1 | value2)
+    case instructions.OPCODE_XOR:
+        return interp.WriteTo(ri, value1 ^ value2)
+    default:
+        return fmt.Errorf("interpreter/interpreter.go: invalid bitwise operation %#02x",opcode)
+    }
+}
+
+// NOT routine: Not
+// Not inverts every bit of the value in register ri. 
+func (interp *Interpreter) Not(ri int32) error {
+    value, err := interp.ReadFrom(ri)
+    if err != nil {
+        return err
+    }
+    return interp.WriteTo(ri, ^value)
+}
+
+// SHL, SHR, SAR routine: Shift
+// Shift shifts the value in register ri by count bits and writes the 
+// result to register ri. SHL shifts left and SHR shifts right (logical),
+// filling with zeros, while SAR shifts right (arithmetic), filling with 
+// copies of the sign bit. The count is treated as an unsigned 32-bit 
+// value, so a count >= 32 (including a negative count) shifts every bit 
+// out: SHL and SHR give 0, and SAR gives 0 or -1 depending on the sign. 
+func (interp *Interpreter) Shift(opcode, ri, count int32) error {
+    value, err := interp.ReadFrom(ri)
+    if err != nil {
+        return err
+    }
+    n := uint32(count)
+    switch opcode {
+    case instructions.OPCODE_SHL, instructions.OPCODE_SHLI:
+        return interp.WriteTo(ri, int32(uint32(value) << n))
+    case instructions.OPCODE_SHR, instructions.OPCODE_SHRI:
+        return interp.WriteTo(ri, int32(uint32(value) >> n))
+    case instructions.OPCODE_SAR, instructions.OPCODE_SARI:
+        return interp.WriteTo(ri, value >> n)
+    default:
+        return fmt.Errorf("interpreter/interpreter.go: invalid shift operation %#02x",opcode)
+    }
+}
+
+// CMP routine: Compare
+// Compare reads the values in registers ri and rj and sets the status 
+// flags: FLAG_ZERO if the values are equal and FLAG_NEGATIVE if the 
+// value in ri is less than the value in rj. The registers are not 
+// modified. 
+func (interp *Interpreter) Compare(ri, rj int32) error {
+    value1, value2, err := interp.ReadPair(ri, rj)
+    if err != nil {
+        return err
+    }
+    interp.Flags = 0
+    if value1 == value2 {
+        interp.Flags |= FLAG_ZERO
+    }
+    if value1 < value2 {
+        interp.Flags |= FLAG_NEGATIVE
+    }
+    return nil
+}
+
+// JEQ, JNE, JLT, JGT, JLE, JGE routine: JumpIf
+// JumpIf tests the condition of the conditional jump opcode against the
+// status flags set by the last CMP instruction and, if the condition 
+// holds, jumps to address as JumpTo does. Otherwise execution continues 
+// with the next instruction. 
+func (interp *Interpreter) JumpIf(opcode, address int32) error {
+    zero := interp.Flags & FLAG_ZERO != 0
+    negative := interp.Flags & FLAG_NEGATIVE != 0
+    var taken bool
+    switch opcode {
+    case instructions.OPCODE_JEQ:
+        taken = zero
+    case instructions.OPCODE_JNE:
+        taken = !zero
+    case instructions.OPCODE_JLT:
+        taken = negative
+    case instructions.OPCODE_JGT:
+        taken = !zero && !negative
+    case instructions.OPCODE_JLE:
+        taken = zero || negative
+    case instructions.OPCODE_JGE:
+        taken = !negative
+    default:
+        return fmt.Errorf("interpreter/interpreter.go: invalid conditional jump %#02x",opcode)
+    }
+    if !taken {
+        return nil
+    }
+    return interp.JumpTo(address)
+}
+
+// CheckData validates a data memory address used by a LD or ST 
+// instruction. If the address is outside of the data memory, then a 
+// segmentation violation error is returned. 
+func (interp *Interpreter) CheckData(mnemonic string, addr int32) error {
+    if addr < 0 || int(addr) >= len(interp.Data) {
+        return fmt.Errorf("gvm: %s addr %d invalid: segmentation violation.",mnemonic,addr)
+    }
+    return nil
+}
+
+// LD routine: Load
+// Load reads the word at data memory address addr and writes it to 
+// register. 
+func (interp *Interpreter) Load(register, addr int32) error {
+    if err := interp.CheckData("LD", addr); err != nil {
+        return err
+    }
+    return interp.WriteTo(register, interp.Data[addr])
+}
+
+// ST routine: Store
+// Store writes the value in register to the word at data memory address
+// addr. 
+func (interp *Interpreter) Store(addr, register int32) error {
+    if err := interp.CheckData("ST", addr); err != nil {
+        return err
+    }
+    value, err := interp.ReadFrom(register)
+    if err != nil {
+        return err
+    }
+    interp.Data[addr] = value
+    return nil
+}
+
+// PUSH routine: Push
+// Push decrements the stack pointer and writes the value in register to
+// the new top of the stack. If the stack is full, then a stack overflow
+// error is returned. 
+func (interp *Interpreter) Push(register int32) error {
+    value, err := interp.ReadFrom(register)
+    if err != nil {
+        return err
+    }
+    if interp.SP <= interp.StackLimit {
+        return fmt.Errorf("gvm: PUSH at addr %d: stack overflow [stack size %d]",interp.PC,int32(len(interp.Data))-interp.StackLimit)
+    }
+    interp.SP--
+    interp.Data[interp.SP] = value
+    return nil
+}
+
+// POP routine: Pop
+// Pop writes the value at the top of the stack to register and 
+// increments the stack pointer. If the stack is empty, then a stack 
+// underflow error is returned. 
+func (interp *Interpreter) Pop(register int32) error {
+    if int(interp.SP) >= len(interp.Data) {
+        return fmt.Errorf("gvm: POP at addr %d: stack underflow [stack is empty]",interp.PC)
+    }
+    if err := interp.WriteTo(register, interp.Data[interp.SP]); err != nil {
+        return err
+    }
+    interp.SP++
+    return nil
+}
+
+// CALL routine: Call
+// Call validates the subroutine address with CheckJump, pushes the 
+// address of the next instruction onto the call stack and jumps to the
+// subroutine. If the call stack is full, then a stack overflow error is
+// returned. 
+func (interp *Interpreter) Call(address int32) error {
+    if err := interp.CheckJump(address); err != nil {
+        return err
+    }
+    if interp.CallDepth >= len(interp.CallStack) {
+        return fmt.Errorf("gvm: CALL at addr %d: stack overflow [max call depth %d]",interp.PC,len(interp.CallStack))
+    }
+    interp.CallStack[interp.CallDepth] = interp.PC + 1
+    interp.CallDepth++
+    interp.PC = address-1
+    return nil
+}
+
+// RET routine: Return
+// Return pops the most recent return address from the call stack and 
+// continues execution there. If the call stack is empty, then a stack
+// underflow error is returned. 
+func (interp *Interpreter) Return() error {
+    if interp.CallDepth == 0 {
+        return fmt.Errorf("gvm: RET at addr %d: stack underflow [RET without CALL]",interp.PC)
+    }
+    interp.CallDepth--
+    interp.PC = interp.CallStack[interp.CallDepth]-1
+    return nil
+}
+
 // STDOUT routine: PrintToStdOut
-// Prints the value at register to terminal 
+// Prints the value at register to the program output 
 func (interp *Interpreter) PrintToStdOut(register int32) error {
     value, err := interp.ReadFrom(register)
     if err != nil {
         return err 
     }
-    fmt.Printf("%d\n",value)
+    fmt.Fprintf(interp.Output,"%d\n",value)
     return nil 
 }
 
+// InputReader returns the buffered reader over Input, creating a new one
+// if Input has been replaced since the last read. 
+func (interp *Interpreter) InputReader() *bufio.Reader {
+    if interp.reader == nil || interp.readerSource != interp.Input {
+        interp.reader = bufio.NewReader(interp.Input)
+        interp.readerSource = interp.Input
+    }
+    return interp.reader
+}
+
+// STDIN routine: ReadInteger
+// ReadInteger skips any leading whitespace in the input, reads the 
+// following word up to the next whitespace character (which is consumed)
+// and writes its integer value to register. The integer is written as 
+// an immediate value would be: a signed decimal or a hexadecimal, binary
+// or octal value with a '0x', '0b' or '0o' prefix. If the input is 
+// exhausted before a word is found, EOF_VALUE is written. A word which 
+// is not an integer or does not fit in 32 bits is an error. 
+func (interp *Interpreter) ReadInteger(register int32) error {
+    reader := interp.InputReader()
+    var word strings.Builder
+    for {
+        c, err := reader.ReadByte()
+        if errors.Is(err, io.EOF) {
+            break
+        }
+        if err != nil {
+            return fmt.Errorf("gvm: STDIN at addr %d: read error: %v",interp.PC,err)
+        }
+        if unicode.IsSpace(rune(c)) {
+            if word.Len() > 0 {
+                break
+            }
+            continue // leading whitespace
+        }
+        word.WriteByte(c)
+    }
+    if word.Len() == 0 {
+        return interp.WriteTo(register, EOF_VALUE)
+    }
+    value, err := ParseInteger(word.String())
+    if errors.Is(err, strconv.ErrRange) {
+        return fmt.Errorf("gvm: STDIN at addr %d: input '%s' out of range [values must fit in 32 bits]",interp.PC,word.String())
+    }
+    if err != nil {
+        return fmt.Errorf("gvm: STDIN at addr %d: invalid integer input '%s'",interp.PC,word.String())
+    }
+    return interp.WriteTo(register, int32(value))
+}
+
+// ParseInteger parses an integer written as an immediate value: an 
+// optional '-' sign followed by decimal digits, or by hexadecimal, binary
+// or octal digits with a '0x', '0b' or '0o' prefix. The value must fit in
+// 32 bits. 
+func ParseInteger(s string) (int32, error) {
+    negative := strings.HasPrefix(s, "-")
+    digits := strings.TrimPrefix(s, "-")
+    base := 10
+    if len(digits) > 2 && digits[0] == '0' {
+        switch digits[1] {
+        case 'x', 'X':
+            base = 16
+        case 'b', 'B':
+            base = 2
+        case 'o', 'O':
+            base = 8
+        }
+        if base != 10 {
+            digits = digits[2:]
+        }
+    }
+    if negative {
+        digits = "-" + digits
+    }
+    value, err := strconv.ParseInt(digits, base, 32)
+    return int32(value), err
+}
+
+// GETC routine: ReadChar
+// ReadChar reads a single byte from the input and writes its value 
+// (0 to 255) to register. If the input is exhausted, EOF_VALUE is 
+// written. 
+func (interp *Interpreter) ReadChar(register int32) error {
+    c, err := interp.InputReader().ReadByte()
+    if errors.Is(err, io.EOF) {
+        return interp.WriteTo(register, EOF_VALUE)
+    }
+    if err != nil {
+        return fmt.Errorf("gvm: GETC at addr %d: read error: %v",interp.PC,err)
+    }
+    return interp.WriteTo(register, int32(c))
+}
+
+// Flush flushes the program output, if the Output supports it, so that 
+// animated output (e.g., ADDV) appears as it is written. 
+func (interp *Interpreter) Flush() {
+    switch output := interp.Output.(type) {
+    case interface{ Sync() error }:
+        output.Sync()
+    case interface{ Flush() error }:
+        output.Flush()
+    }
+}
+
+// PUTC routine: PutChar
+// PutChar prints the low byte of the value at register as a character,
+// without a newline. 
+func (interp *Interpreter) PutChar(register int32) error {
+    value, err := interp.ReadFrom(register)
+    if err != nil {
+        return err
+    }
+    fmt.Fprintf(interp.Output,"%c",byte(value))
+    return nil
+}
+
+// PRINT routine: PrintString
+// PrintString prints the string at index in the string table, without a
+// newline. 
+func (interp *Interpreter) PrintString(index int32) error {
+    if index < 0 || int(index) >= len(interp.Strings) {
+        return fmt.Errorf("gvm: PRINT at addr %d: invalid string index %d",interp.PC,index)
+    }
+    fmt.Fprint(interp.Output,interp.Strings[index])
+    return nil
+}
+
+// PRINTD, PRINTX, PRINTB routine: PrintFormatted
+// PrintFormatted prints the value at register, without a newline, in the
+// format given by opcode: PRINTD prints a signed decimal, and PRINTX and 
+// PRINTB print the 32 bits of the value in hexadecimal (0x...) and 
+// binary (0b...). 
+func (interp *Interpreter) PrintFormatted(opcode, register int32) error {
+    value, err := interp.ReadFrom(register)
+    if err != nil {
+        return err
+    }
+    switch opcode {
+    case instructions.OPCODE_PRINTD:
+        fmt.Fprintf(interp.Output,"%d",value)
+    case instructions.OPCODE_PRINTX:
+        fmt.Fprintf(interp.Output,"%#x",uint32(value))
+    case instructions.OPCODE_PRINTB:
+        fmt.Fprintf(interp.Output,"0b%b",uint32(value))
+    default:
+        return fmt.Errorf("interpreter/interpreter.go: invalid print format %#02x",opcode)
+    }
+    return nil
+}
+
 // PRINTR routine: PrintRegisters()
-// Prints all registers and their corresponding values
+// Prints all registers and their corresponding values, followed by the
+// stack pointer and the value at the top of the stack. 
 func (interp *Interpreter) PrintRegisters() error {
     for i := 0; i < 10; i++ {
         i32 := int32(i)
@@ -238,7 +1167,13 @@ func (interp *Interpreter) PrintRegisters() error {
         if err != nil {
             return err
         }
-        fmt.Printf("R%d: %d\n",i,value)
+        fmt.Fprintf(interp.Output,"R%d: %d\n",i,value)
+    }
+    fmt.Fprintf(interp.Output,"SP: %d\n",interp.SP)
+    if int(interp.SP) < len(interp.Data) {
+        fmt.Fprintf(interp.Output,"TOS: %d\n",interp.Data[interp.SP])
+    } else {
+        fmt.Fprintf(interp.Output,"TOS: (empty)\n")
     }
     return nil
 }
@@ -266,42 +1201,42 @@ func (interp *Interpreter) AddV(ri, rj int32) error {
     k := int32(i + j)
     interp.WriteTo(ri, k)
 
-    fmt.Printf("%d + %d ",i,j)
+    fmt.Fprintf(interp.Output,"%d + %d ",i,j)
 
     // colour string functions for the first i stars
     starColor1 := color.New(color.FgRed).SprintFunc()
     message1 := strings.Repeat("* ",i)
     for _, char := range message1 {
-        fmt.Print(starColor1(string(char))) // applies function to string
+        fmt.Fprint(interp.Output,starColor1(string(char))) // applies function to string
         time.Sleep(100 * time.Millisecond)
-        os.Stdout.Sync() 
+        interp.Flush()
     }
 
     time.Sleep(100 * time.Millisecond)
-    fmt.Printf("+ ")
+    fmt.Fprintf(interp.Output,"+ ")
     time.Sleep(100 * time.Millisecond)
 
     // the j stars 
     message2 := strings.Repeat("* ",j)
     starColor2 := color.New(color.FgBlue).SprintFunc()
     for _, char := range message2 {
-        fmt.Print(starColor2(string(char)))
+        fmt.Fprint(interp.Output,starColor2(string(char)))
         time.Sleep(100 * time.Millisecond)
-        os.Stdout.Sync()
+        interp.Flush()
     }
 
     time.Sleep(100 * time.Millisecond)
-    fmt.Printf("= ")
+    fmt.Fprintf(interp.Output,"= ")
 
     // the i + j stars 
     message3 := strings.Repeat("* ",i+j)
     starColor3 := color.New(color.FgGreen).SprintFunc()
     for _, char := range message3 {
-        fmt.Print(starColor3(string(char)))
+        fmt.Fprint(interp.Output,starColor3(string(char)))
         time.Sleep(100 * time.Millisecond)
-        os.Stdout.Sync()
+        interp.Flush()
     }
-    fmt.Println("")
+    fmt.Fprintln(interp.Output)
     return nil
 }
 
@@ -340,6 +1275,15 @@ func (interp *Interpreter) Blink(shape int32) error {
     }
 }
 
+// PrintColor prints s to the program output in colour c, followed by a
+// newline if s does not already end with one. 
+func (interp *Interpreter) PrintColor(c *color.Color, s string) {
+    if !strings.HasSuffix(s, "\n") {
+        s += "\n"
+    }
+    c.Fprint(interp.Output, s)
+}
+
 // DrawHeart prints a heart shape to the screen. If blink is
 // set to 1 then the heart will blink on the screen. 
 func (interp *Interpreter) DrawHeart(blink int) {
@@ -351,10 +1295,10 @@ func (interp *Interpreter) DrawHeart(blink int) {
         '
 `
     if blink == 0 {
-        color.Red(heart)
+        interp.PrintColor(color.New(color.FgRed), heart)
     } else {
         blinkHeart := color.New(color.FgRed, color.BlinkSlow).SprintFunc()
-        print(blinkHeart(heart))
+        fmt.Fprint(interp.Output, blinkHeart(heart))
     }
     return
 }
@@ -371,10 +1315,10 @@ func (interp *Interpreter) DrawBird(blink int) {
       _|_
       `
     if blink == 0 {
-        color.Blue(bird)
+        interp.PrintColor(color.New(color.FgBlue), bird)
     } else {
         blinkBird := color.New(color.FgBlue, color.BlinkSlow).SprintFunc()
-        print(blinkBird(bird))
+        fmt.Fprint(interp.Output, blinkBird(bird))
     }
     return
 }
@@ -8,6 +8,7 @@ package lexer
 
 import (
      "fmt"
+     "errors"
      "unicode"
      "strings"
      "strconv"
@@ -19,6 +20,12 @@ type Lexer struct {
     Input string 
     Position int
     CurrentChar byte
+
+    // Line is the line number of the input in the source code, and Start
+    // is the position in the input where the current token begins. They 
+    // are used to locate tokens and errors in the source code. 
+    Line int
+    Start int
 }
 
 // Initialize a lexer with an input string. The input string is a 
@@ -26,11 +33,23 @@ type Lexer struct {
 // current character is initialzed as the first character in the 
 // input. 
 func New(input string) *Lexer {
+    return NewAt(input, 1)
+}
+
+// NewAt initializes a lexer with an input string found at the given line
+// number of the user's source code. 
+func NewAt(input string, line int) *Lexer {
     var currentChar byte = 0
     if len(input) > 0 {
         currentChar = input[0]
     } 
-    return &Lexer{Input: input, Position: 0, CurrentChar: currentChar} 
+    return &Lexer{Input: input, Position: 0, CurrentChar: currentChar, Line: line} 
+}
+
+// Errorf creates an error annotated with the line and column of the 
+// token being scanned. 
+func (lex *Lexer) Errorf(format string, args ...interface{}) error {
+    return token.NewError(lex.Line, lex.Start + 1, fmt.Errorf(format, args...))
 }
 
 // GetNextChar advance position to the next character in the input for 
@@ -45,6 +64,15 @@ func (lex *Lexer) GetNextChar() {
     return
 }
 
+// Peek returns the character following the current character without 
+// advancing the position, or 0 at the end of the input. 
+func (lex *Lexer) Peek() byte {
+    if lex.Position + 1 < len(lex.Input) {
+        return lex.Input[lex.Position + 1]
+    }
+    return 0
+}
+
 // IgnoreWhiteSpace advances the current position in the input if a 
 // whitespace is encountered 
 func (lex *Lexer) IgnoreWhiteSpace() {
@@ -54,8 +82,18 @@ func (lex *Lexer) IgnoreWhiteSpace() {
     return
 }
 
+// IgnoreComment advances the current position to the end of the input
+// when a comment is encountered. Comments begin with a ';' or '#' and 
+// run to the end of the line. 
+func (lex *Lexer) IgnoreComment() {
+    lex.Position = len(lex.Input)
+    lex.CurrentChar = 0
+    return
+}
+
 // Delimiter determines if the character immediately preceeding the 
-// current character being processed was a comma (',') or a space (' ').
+// current character being processed was a comma (','), a space (' ') or
+// an opening bracket ('[').
 // This is used to catch the syntax error case where delimiters are 
 // missing between valid tokens. (E.g., JUMP2, DRAW$heart). Note that
 // it is sufficient to check if a '$' is preceeded by a comma or a space
@@ -63,7 +101,7 @@ func (lex *Lexer) IgnoreWhiteSpace() {
 func (lex *Lexer) Delimiter() bool {
     if lex.Position > 0 {
         previousChar := lex.Input[lex.Position - 1]
-        return previousChar == ' ' || previousChar == ','
+        return previousChar == ' ' || previousChar == ',' || previousChar == '['
     }
     return false // position = 0
 }
@@ -81,53 +119,198 @@ func (lex *Lexer) Register() bool {
 // Integer parses a multi-digit integer string and returns it as an int32 value. 
 // int32 values are used to be consistent with the ISA of the native OS which 
 // the source code was written for as register values in the ISA hold int32 values.
+//
+// Integer literals may be signed ('-') and may be written in decimal, or in
+// hexadecimal, binary or octal with a '0x', '0b' or '0o' prefix (e.g., -5,
+// 0xFF, 0b1010, 0o17). Register indices (immediately following an 'r' or 
+// 'R') are always unsigned decimals. 
 func (lex *Lexer) Integer() (int32, error) {
+    base := 10
+    negative := false
     if !lex.Register() {
         if !lex.Delimiter() {
-            return 0, fmt.Errorf("gvm: syntax error: unexpected INT (missing delimiter)")
+            return 0, lex.Errorf("gvm: syntax error: unexpected INT (missing delimiter)")
+        }
+        // sign 
+        if lex.CurrentChar == '-' {
+            negative = true
+            lex.GetNextChar()
+        }
+        // base prefix 
+        if lex.CurrentChar == '0' && lex.Position + 1 < len(lex.Input) {
+            switch lex.Input[lex.Position + 1] {
+            case 'x', 'X':
+                base = 16
+            case 'b', 'B':
+                base = 2
+            case 'o', 'O':
+                base = 8
+            }
+            if base != 10 {
+                lex.GetNextChar()
+                lex.GetNextChar()
+            }
         }
     }
     integerString := ""
-    for lex.CurrentChar != 0 && unicode.IsDigit(rune(lex.CurrentChar)) {
+    for lex.CurrentChar != 0 && Digit(lex.CurrentChar, base) {
         integerString += string(lex.CurrentChar)
         lex.GetNextChar()
     }
-    integer, err := strconv.ParseInt(integerString, 10, 32) // returns an int64 value 
+    // a base prefix without digits, e.g. 0x 
+    if integerString == "" && base != 10 {
+        return 0, lex.Errorf("gvm: missing digits after '%s'",lex.Input[lex.Position - 2:lex.Position])
+    }
+    // a digit which is not valid in the base, e.g. 0b102 
+    if unicode.IsDigit(rune(lex.CurrentChar)) || (base == 16 && unicode.IsLetter(rune(lex.CurrentChar))) {
+        return 0, lex.Errorf("gvm: invalid digit '%c' in base %d literal",rune(lex.CurrentChar),base)
+    }
+    if negative {
+        integerString = "-" + integerString
+    }
+    integer, err := strconv.ParseInt(integerString, base, 64) // returns an int64 value 
     if err != nil {
-        return 0, fmt.Errorf("gvm: lexer: failed to convert input to integer %w",err)
+        if errors.Is(err, strconv.ErrRange) && negative {
+            return 0, lex.Errorf("gvm: register integer underflow error")
+        }
+        if errors.Is(err, strconv.ErrRange) {
+            return 0, lex.Errorf("gvm: register integer overflow error")
+        }
+        return 0, lex.Errorf("gvm: lexer: failed to convert input to integer %w",err)
     }
     // check for integer overflow
     if integer > math.MaxInt32 {
-        return 0, fmt.Errorf("gvm: register integer overflow error")
+        return 0, lex.Errorf("gvm: register integer overflow error")
     }
     // check for integer underflow
     if integer < math.MinInt32 {
-        return 0, fmt.Errorf("gvm: register integer underflow error")
+        return 0, lex.Errorf("gvm: register integer underflow error")
     }
     integer32 := int32(integer) // convert to int32 
     return integer32, nil    
 }
 
+// Digit reports whether c is a valid digit in the given base (2, 8, 10 or
+// 16). 
+func Digit(c byte, base int) bool {
+    switch base {
+    case 2:
+        return c == '0' || c == '1'
+    case 8:
+        return c >= '0' && c <= '7'
+    case 16:
+        return unicode.IsDigit(rune(c)) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+    default:
+        return unicode.IsDigit(rune(c))
+    }
+}
+
+// Character parses a quoted character literal (e.g., 'A') and returns the 
+// character's value as an int32. The escape sequences \n, \t, \r, \0, \\ and
+// \' are supported. Like integers, a character literal must be preceded 
+// by a delimiter. 
+func (lex *Lexer) Character() (int32, error) {
+    if !lex.Delimiter() {
+        return 0, lex.Errorf("gvm: syntax error: unexpected INT (missing delimiter)")
+    }
+    lex.GetNextChar() // opening quote 
+    value := lex.CurrentChar
+    switch value {
+    case 0:
+        return 0, lex.Errorf("gvm: unterminated character literal")
+    case '\'':
+        return 0, lex.Errorf("gvm: empty character literal")
+    case '\\':
+        lex.GetNextChar()
+        switch lex.CurrentChar {
+        case 'n':
+            value = '\n'
+        case 't':
+            value = '\t'
+        case 'r':
+            value = '\r'
+        case '0':
+            value = 0
+        case '\\', '\'':
+            value = lex.CurrentChar
+        default:
+            return 0, lex.Errorf("gvm: invalid escape sequence in character literal")
+        }
+    }
+    lex.GetNextChar()
+    if lex.CurrentChar != '\'' {
+        return 0, lex.Errorf("gvm: unterminated character literal")
+    }
+    lex.GetNextChar() // closing quote 
+    return int32(value), nil
+}
+
+// StringLiteral parses a double-quoted string literal (e.g., "hello\n") and 
+// returns its text. The escape sequences \n, \t, \r, \0, \\ and \" are
+// supported. A string literal must be preceded by a delimiter and must be
+// closed on the same line. 
+func (lex *Lexer) StringLiteral() (string, error) {
+    if !lex.Delimiter() {
+        return "", lex.Errorf("gvm: syntax error: unexpected STRING (missing delimiter)")
+    }
+    lex.GetNextChar() // opening quote 
+    var builder strings.Builder
+    for lex.CurrentChar != '"' {
+        c := lex.CurrentChar
+        switch c {
+        case 0:
+            return "", lex.Errorf("gvm: unterminated string literal")
+        case '\\':
+            lex.GetNextChar()
+            switch lex.CurrentChar {
+            case 'n':
+                c = '\n'
+            case 't':
+                c = '\t'
+            case 'r':
+                c = '\r'
+            case '0':
+                c = 0
+            case '\\', '"':
+                c = lex.CurrentChar
+            default:
+                return "", lex.Errorf("gvm: invalid escape sequence in string literal")
+            }
+        }
+        builder.WriteByte(c)
+        lex.GetNextChar()
+    }
+    lex.GetNextChar() // closing quote 
+    return builder.String(), nil
+}
+
 // RegisterIndex attempts to obtain a valid register immediately following an
 // 'r' or 'R'. The register must be preceeded by a comma (',') or space (' ')
 // and must be less than two digits. 
 func (lex *Lexer) RegisterIndex() (int32, error) {
 
+    // a lone 'r' is not a label name, e.g. 'r:' or 'JUMP r' 
+    if !unicode.IsDigit(rune(lex.Peek())) {
+        if lex.CurrentChar == 'r' && lex.Peek() == ':' {
+            return 0, lex.Errorf("gvm: invalid label name 'r' [a label may not be named r]")
+        }
+        if lex.CurrentChar == 'r' {
+            return 0, lex.Errorf("gvm: missing register index [use registers r0:r9; a label may not be named r]")
+        }
+        return 0, lex.Errorf("gvm: missing register index.")
+    }
     // if previous char was not a comma or a space, then this r should not be here.
     if !lex.Delimiter() {
-        return 0, fmt.Errorf("gvm: unexpected REG (missing delimiter)")
+        return 0, lex.Errorf("gvm: unexpected REG (missing delimiter)")
     }
     // otherwise, advance to next character and get the register index.
     lex.GetNextChar()
-    if unicode.IsSpace(rune(lex.CurrentChar)) {
-        return 0, fmt.Errorf("gvm: missing register index.")
-    }
     registerIndex, err := lex.Integer() 
     if err != nil {
         return 0, err
     }
     if registerIndex > 9 {
-        return 0, fmt.Errorf("gvm: register indices must be between 0 and 9.")
+        return 0, lex.Errorf("gvm: register indices must be between 0 and 9.")
     }
     return registerIndex, nil
 }
@@ -141,7 +324,7 @@ func (lex *Lexer) Command() (string, error) {
     var builder strings.Builder
     for lex.CurrentChar != 0 && unicode.IsUpper(rune(lex.CurrentChar)) {
         if builder.Len() > 10 {
-            return "", fmt.Errorf("gvm: invalid command: max length reached (10).")
+            return "", lex.Errorf("gvm: invalid command: max length reached (10).")
         }
         builder.WriteRune(rune(lex.CurrentChar))
         lex.GetNextChar()
@@ -156,47 +339,104 @@ func (lex *Lexer) Command() (string, error) {
 // If no error occured, then the shape string is returned for further verification. 
 func (lex *Lexer) Shape() (string, error) {
     if !lex.Delimiter() {
-        return "", fmt.Errorf("gvm: shape declaration must be preceded by ' '")
+        return "", lex.Errorf("gvm: shape declaration must be preceded by ' '")
     }
     lex.GetNextChar()
     var builder strings.Builder 
     for lex.CurrentChar != 0 && unicode.IsLower(rune(lex.CurrentChar)) {
         if builder.Len() > 6 {
-            return "", fmt.Errorf("gvm: invalid shape.")
+            return "", lex.Errorf("gvm: invalid shape.")
         }
         builder.WriteRune(rune(lex.CurrentChar))
         lex.GetNextChar()
     }
     if builder.Len() == 0 {
-        return "", fmt.Errorf("gvm: missing shape after '$'")
+        return "", lex.Errorf("gvm: missing shape after '$'")
     }
     return builder.String(), nil
 }
 
+// Identifier builds a label name. Label names begin with a lowercase 
+// letter or underscore, followed by any number of lowercase letters, 
+// digits or underscores. 
+//
+// If the name is immediately followed by a colon (':') then it is a label
+// definition, which must be the first token on the line. Otherwise, the
+// name is a label reference, which must be preceded by a delimiter like 
+// any other operand. Any other lowercase word is an error as input is 
+// case sensitive. 
+func (lex *Lexer) Identifier() (*token.Token, error) {
+    start := lex.Position
+    var builder strings.Builder
+    for lex.CurrentChar != 0 && IdentifierChar(lex.CurrentChar) {
+        builder.WriteRune(rune(lex.CurrentChar))
+        lex.GetNextChar()
+    }
+    name := builder.String()
+
+    // label definition 
+    if lex.CurrentChar == ':' {
+        if strings.TrimSpace(lex.Input[:start]) != "" {
+            return nil, lex.Errorf("gvm: label definition '%s' must begin the line",name)
+        }
+        lex.GetNextChar()
+        return token.NewLiteral(token.LABEL, name), nil
+    }
+    // label reference 
+    if start > 0 {
+        previousChar := lex.Input[start - 1]
+        if previousChar == ' ' || previousChar == ',' {
+            return token.NewLiteral(token.IDENT, name), nil
+        }
+    }
+    return nil, lex.Errorf("gvm: input is case sensitive: invalid '%c'",rune(lex.Input[start]))
+}
+
+// IdentifierChar reports whether c may appear in a label name. 
+func IdentifierChar(c byte) bool {
+    return c == '_' || unicode.IsLower(rune(c)) || unicode.IsDigit(rune(c))
+}
+
 // GetNextToken is the generating function of the lexer where the input is analyzed
 // one character at a time. The input is traversed and tokens are built using the 
 // helper functions above. If an error is returned from any helper function, then a
 // nil token is returned and the error is propagated to the parser where it is 
 // handled. 
 //
-// REG, INT, and COMMA tokens are immediately returned along with a nil 
-// error once successfully created.
+// REG, INT, COMMA, STRING, LABEL and IDENT tokens are immediately returned along
+// with a nil error once successfully created.
 //
 // COMMAND and SHAPE tokens require further verification. If the command or shape
 // is not valid within the source's ISA, then a nil token and error are returned
 // to the parser. Otherwise, the COMMAND/SHAPE tokens are returned with a nil 
 // error. 
-func (lex *Lexer) GetNextToken() (*token.Token, error) {
+//
+// Every token returned is stamped with its line and column, and every error
+// returned carries the line and column at which the offending token begins.
+func (lex *Lexer) GetNextToken() (tok *token.Token, err error) {
+    defer func() {
+        if tok != nil {
+            tok.Line = lex.Line
+            tok.Column = lex.Start + 1
+        }
+    }()
 
     for lex.CurrentChar != 0 {
+        lex.Start = lex.Position
         switch {
 
         // Whitespace
         case unicode.IsSpace(rune(lex.CurrentChar)): 
             lex.IgnoreWhiteSpace()
 
-        // Register 
-        case unicode.ToLower(rune(lex.CurrentChar)) == 'r':  
+        // Label - a lowercase 'r' followed by a letter begins a label name
+        // rather than a register
+        case lex.CurrentChar == 'r' && (unicode.IsLower(rune(lex.Peek())) || lex.Peek() == '_'):
+            return lex.Identifier()
+
+        // Register - an uppercase 'R' followed by an uppercase letter begins
+        // a command (e.g., RET) rather than a register
+        case lex.CurrentChar == 'r' || (lex.CurrentChar == 'R' && !unicode.IsUpper(rune(lex.Peek()))):
             regIndex, err := lex.RegisterIndex()
             if err != nil {
                return nil, err
@@ -211,11 +451,48 @@ func (lex *Lexer) GetNextToken() (*token.Token, error) {
             }
             return token.New(token.INT, integer), nil
 
+        // Signed integer 
+        case lex.CurrentChar == '-' && lex.Position + 1 < len(lex.Input) && 
+            unicode.IsDigit(rune(lex.Input[lex.Position + 1])):
+            integer, err := lex.Integer()
+            if err != nil {
+                return nil, err
+            }
+            return token.New(token.INT, integer), nil
+
+        // Character literal (an INT token holding the character's value)
+        case lex.CurrentChar == '\'':
+            character, err := lex.Character()
+            if err != nil {
+                return nil, err
+            }
+            return token.New(token.INT, character), nil
+
+        // String literal 
+        case lex.CurrentChar == '"':
+            literal, err := lex.StringLiteral()
+            if err != nil {
+                return nil, err
+            }
+            return token.NewLiteral(token.STRING, literal), nil
+
+        // Comment 
+        case lex.CurrentChar == ';' || lex.CurrentChar == '#':
+            lex.IgnoreComment()
+
         // Comma 
         case lex.CurrentChar == ',':
             lex.GetNextChar()
             return token.New(token.COMMA,0), nil
 
+        // Brackets (memory address)
+        case lex.CurrentChar == '[':
+            lex.GetNextChar()
+            return token.New(token.LBRACKET,0), nil
+        case lex.CurrentChar == ']':
+            lex.GetNextChar()
+            return token.New(token.RBRACKET,0), nil
+
         // Shape
         case lex.CurrentChar == '$':
             shape, err := lex.Shape()
@@ -229,16 +506,16 @@ func (lex *Lexer) GetNextToken() (*token.Token, error) {
             case "bird":
                 return token.New(token.SHAPE,2), nil // 2 denotes bird
             default:
-                return nil, fmt.Errorf("gvm: invalid shape: '%s'",shape)
+                return nil, lex.Errorf("gvm: invalid shape: '%s'",shape)
             }
                 
-        // Lowercase letter which is not 'r' - invalid 
-        case unicode.IsLower(rune(lex.CurrentChar)):
-            return nil, fmt.Errorf("gvm: input is case sensitive: invalid '%c'",rune(lex.CurrentChar))
+        // Lowercase letter which is not 'r' - label definition or reference
+        case unicode.IsLower(rune(lex.CurrentChar)) || lex.CurrentChar == '_':
+            return lex.Identifier()
     
         // Punctiation or symbol which is not ',' - invalid
         case unicode.IsPunct(rune(lex.CurrentChar)) || unicode.IsSymbol(rune(lex.CurrentChar)):
-            return nil, fmt.Errorf("gvm: invalid character: %c",rune(lex.CurrentChar))
+            return nil, lex.Errorf("gvm: invalid character: %c",rune(lex.CurrentChar))
 
         // Command 
         case unicode.IsUpper(rune(lex.CurrentChar)):
@@ -264,14 +541,101 @@ func (lex *Lexer) GetNextToken() (*token.Token, error) {
                 return token.New(token.DRAW, 0), nil
             case "BLINK":
                 return token.New(token.BLINK, 0), nil
+            case "SUB":
+                return token.New(token.SUB, 0), nil
+            case "MUL":
+                return token.New(token.MUL, 0), nil
+            case "DIV":
+                return token.New(token.DIV, 0), nil
+            case "MOD":
+                return token.New(token.MOD, 0), nil
+            case "NEG":
+                return token.New(token.NEG, 0), nil
+            case "CMP":
+                return token.New(token.CMP, 0), nil
+            case "JEQ":
+                return token.New(token.JEQ, 0), nil
+            case "JNE":
+                return token.New(token.JNE, 0), nil
+            case "JLT":
+                return token.New(token.JLT, 0), nil
+            case "JGT":
+                return token.New(token.JGT, 0), nil
+            case "JLE":
+                return token.New(token.JLE, 0), nil
+            case "JGE":
+                return token.New(token.JGE, 0), nil
+            case "CALL":
+                return token.New(token.CALL, 0), nil
+            case "RET":
+                return token.New(token.RET, 0), nil
+            case "LD":
+                return token.New(token.LD, 0), nil
+            case "ST":
+                return token.New(token.ST, 0), nil
+            case "PUSH":
+                return token.New(token.PUSH, 0), nil
+            case "POP":
+                return token.New(token.POP, 0), nil
+            case "AND":
+                return token.New(token.AND, 0), nil
+            case "OR":
+                return token.New(token.OR, 0), nil
+            case "XOR":
+                return token.New(token.XOR, 0), nil
+            case "NOT":
+                return token.New(token.NOT, 0), nil
+            case "SHL":
+                return token.New(token.SHL, 0), nil
+            case "SHR":
+                return token.New(token.SHR, 0), nil
+            case "SAR":
+                return token.New(token.SAR, 0), nil
+            case "MOV":
+                return token.New(token.MOV, 0), nil
+            case "ADDI":
+                return token.New(token.ADDI, 0), nil
+            case "SUBI":
+                return token.New(token.SUBI, 0), nil
+            case "MULI":
+                return token.New(token.MULI, 0), nil
+            case "DIVI":
+                return token.New(token.DIVI, 0), nil
+            case "MODI":
+                return token.New(token.MODI, 0), nil
+            case "ANDI":
+                return token.New(token.ANDI, 0), nil
+            case "ORI":
+                return token.New(token.ORI, 0), nil
+            case "XORI":
+                return token.New(token.XORI, 0), nil
+            case "HALT":
+                return token.New(token.HALT, 0), nil
+            case "NOP":
+                return token.New(token.NOP, 0), nil
+            case "STDIN":
+                return token.New(token.STDIN, 0), nil
+            case "GETC":
+                return token.New(token.GETC, 0), nil
+            case "PUTC":
+                return token.New(token.PUTC, 0), nil
+            case "PRINT":
+                return token.New(token.PRINT, 0), nil
+            case "PRINTD":
+                return token.New(token.PRINTD, 0), nil
+            case "PRINTX":
+                return token.New(token.PRINTX, 0), nil
+            case "PRINTB":
+                return token.New(token.PRINTB, 0), nil
             default:
-                return nil, fmt.Errorf("gvm: undefined: '%s'.",command)
+                return nil, lex.Errorf("gvm: undefined: '%s'.",command)
             }
         // something else 
         default:
-            return nil, fmt.Errorf("gvm: unrecognized symbol '%c'",rune(lex.CurrentChar))
+            return nil, lex.Errorf("gvm: unrecognized symbol '%c'",rune(lex.CurrentChar))
         }
     }
     // EOF is a dummy-type for final return. 
+    lex.Start = lex.Position
     return token.New(token.EOF, 0), nil
 }
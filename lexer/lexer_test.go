@@ -1,7 +1,9 @@
 package lexer
 
 import (
+    "strings"
     "testing"
+    "gvm/token"
 )
 
 type TestCase struct {
@@ -34,9 +36,51 @@ func TestLexer(t *testing.T) {
         {" 10983",true},
         {"a123", false},
         {"123", false},
+        {" -5", true},
+        {",-2147483648", true},
+        {" -2147483649", false}, // underflow
+        {" 2147483647", true},
+        {" 2147483648", false},  // overflow
+        {" 0xFF", true},
+        {" 0x7fffffff", true},
+        {" 0x80000000", false},
+        {" -0x10", true},
+        {" 0b1010", true},
+        {" 0b102", false},
+        {" 0o17", true},
+        {" 0o8", false},
+        {" 0x", false},
+        {" 0xFG", false},
+        {"0xFF", false},  // missing delimiter
+        {" -", false},
+        {" - 5", false},
+        {" 'A'", true},
+        {",';'", true},
+        {" '\\n'", true},
+        {" '\\''", true},
+        {" ''", false},
+        {" 'AB'", false},
+        {" 'A", false},
+        {" '\\q'", false},
+        {"'A'", false},  // missing delimiter
+
+        // strings
+        {" \"hello\"", true},
+        {" \"\"", true},
+        {",\"a; b # c\"", true},
+        {" \"tab\\there\\n\"", true},
+        {" \"say \\\"hi\\\"\"", true},
+        {" \"open", false},
+        {" \"bad \\q\"", false},
+        {" \"trailing\\", false},
+        {"\"hello\"", false},  // missing delimiter
 
         // symbols 
         {",", true},
+        {"[", true},
+        {"]", true},
+        {"[r1", true},
+        {"[12", true},
         {".", false},
         {"&", false},
 
@@ -44,6 +88,33 @@ func TestLexer(t *testing.T) {
         {"ADD", true},
         {"LDI", true},
         {"STDOUT", true},
+        {"CALL", true},
+        {"RET", true},
+        {"LD", true},
+        {"ST", true},
+        {"PUSH", true},
+        {"POP", true},
+        {"AND", true},
+        {"OR", true},
+        {"XOR", true},
+        {"NOT", true},
+        {"SHL", true},
+        {"SHR", true},
+        {"SAR", true},
+        {"MOV", true},
+        {"ADDI", true},
+        {"XORI", true},
+        {"HALT", true},
+        {"NOP", true},
+        {"STDIN", true},
+        {"GETC", true},
+        {"PUTC", true},
+        {"PRINT", true},
+        {"PRINTD", true},
+        {"PRINTX", true},
+        {"PRINTB", true},
+        {"PRINTS", false},
+        {" RET", true},
         {"    ADD  ",true},
         {"AD", false},  // unknown command
         {"add", false},  // lowercase
@@ -56,6 +127,26 @@ func TestLexer(t *testing.T) {
         {" r", false},
         {"r",false},
         {"1",false},
+
+        // labels
+        {"loop:", true},
+        {"  loop:", true},
+        {"ready:", true},
+        {"r_1:", true},
+        {"_end:", true},
+        {" loop", true},   // label reference
+        {",done", true},
+        {"loop", false},   // neither a definition nor a reference
+        {"Loop:", false},
+        {"r1:", false},
+
+        // comments and blank lines
+        {"", true},
+        {"    ", true},
+        {"; comment", true},
+        {"# comment", true},
+        {"  ;; ADD r1,r2", true},
+        {"#.&", true},
     }
 
     for _, testCase := range testCases {
@@ -70,3 +161,111 @@ func TestLexer(t *testing.T) {
         }
     }
 }
+
+// Tests that tokens and errors carry the line and column of the token. 
+func TestPositions(t *testing.T) {
+    lex := NewAt("  LDI r1, 12", 7)
+    columns := []int{3, 7, 9, 11, 13}
+    for _, column := range columns {
+        tok, err := lex.GetNextToken()
+        if err != nil {
+            t.Fatalf("FAIL: error returned from valid input: %v", err)
+        }
+        if tok.Line != 7 || tok.Column != column {
+            t.Errorf("FAIL: %v: expected 7:%d, got %d:%d", tok, column, tok.Line, tok.Column)
+        }
+    }
+
+    lex = NewAt("LDI r1, 1.", 3)
+    var err error
+    for err == nil {
+        _, err = lex.GetNextToken()
+    }
+    sourceErr, ok := err.(*token.SourceError)
+    if !ok || sourceErr.Line != 3 || sourceErr.Column != 10 {
+        t.Errorf("FAIL: expected error at 3:10, got: %v", err)
+    }
+
+    // a label may not be named r
+    for _, input := range []string{"r: NOP", "JUMP r", "STDOUT r ; no index"} {
+        lex = NewAt(input, 1)
+        for err = nil; err == nil; {
+            _, err = lex.GetNextToken()
+        }
+        if !strings.Contains(err.Error(), "may not be named r") {
+            t.Errorf("FAIL: %s: expected an invalid label name error, got: %v", input, err)
+        }
+    }
+
+    // a base prefix without digits
+    for _, prefix := range []string{"0x", "0B", "0o"} {
+        lex = NewAt("LDI r1, " + prefix, 4)
+        for err = nil; err == nil; {
+            _, err = lex.GetNextToken()
+        }
+        sourceErr, ok = err.(*token.SourceError)
+        if !ok || sourceErr.Line != 4 || sourceErr.Column != 9 || !strings.Contains(err.Error(), "missing digits after '" + prefix + "'") {
+            t.Errorf("FAIL: LDI r1, %s: expected missing digits at 4:9, got: %v", prefix, err)
+        }
+    }
+}
+
+// Tests the values of signed, hexadecimal, binary, octal and character 
+// literals. 
+func TestIntegerValues(t *testing.T) {
+    testCases := []struct {
+        input string
+        value int32
+    }{
+        {" 42", 42},
+        {" -5", -5},
+        {" 0xFF", 255},
+        {" 0XfF", 255},
+        {" -0x80000000", -2147483648},
+        {" 0b1010", 10},
+        {" 0o17", 15},
+        {" 007", 7},
+        {" 'A'", 65},
+        {" ' '", 32},
+        {" '\\n'", 10},
+        {" '\\0'", 0},
+        {" '\\\\'", 92},
+    }
+
+    for _, testCase := range testCases {
+        tok, err := New(testCase.input).GetNextToken()
+        if err != nil {
+            t.Errorf("FAIL: error returned from valid input: %s: error message: %v", testCase.input, err)
+            continue
+        }
+        if tok.TokenType != token.INT || tok.Value != testCase.value {
+            t.Errorf("FAIL: %s: expected INT %d, got %v", testCase.input, testCase.value, tok)
+        }
+    }
+}
+
+// Tests the text of string literals with their escape sequences resolved.
+func TestStringValues(t *testing.T) {
+    testCases := []struct {
+        input string
+        literal string
+    }{
+        {" \"hello\"", "hello"},
+        {" \"\"", ""},
+        {" \"a; b # c\"", "a; b # c"},
+        {" \"x\\ty\\n\"", "x\ty\n"},
+        {" \"\\\"q\\\" \\\\\"", "\"q\" \\"},
+        {" \"nul\\0\"", "nul\x00"},
+    }
+
+    for _, testCase := range testCases {
+        tok, err := New(testCase.input).GetNextToken()
+        if err != nil {
+            t.Errorf("FAIL: error returned from valid input: %s: error message: %v", testCase.input, err)
+            continue
+        }
+        if tok.TokenType != token.STRING || tok.Literal != testCase.literal {
+            t.Errorf("FAIL: %s: expected STRING %q, got %v", testCase.input, testCase.literal, tok)
+        }
+    }
+}
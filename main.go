@@ -1,15 +1,26 @@
-// Package main initializes the Virtual Machine. To execute a program, 
-// use 'run file' where 'file' is the name of your program. Enter 
-// exit to exit. 
+// Package main initializes the Virtual Machine. Given a command (e.g.,
+// 'gvm run prog.sus', see USAGE), gvm runs it without any prompt and exits
+// with a meaningful exit code. Otherwise it starts an interactive prompt.
+// At the prompt, to execute a program, 
+// use 'run file' where 'file' is the name of your program, or use 
+// 'debug file' to step through it in the debugger. Use 'run --trace file'
+// (or 'run --trace=json file') to write a trace of every instruction 
+// executed to STDERR, or 'disasm file' to print the program as it is 
+// disassembled from its bytecode. Enter exit to exit. The process exit
+// code is the exit status of the last program run: the status given to
+// HALT (255 for a status outside 0-255), or 1 if the program failed.
 package main
 
 import (
+    "io"
     "fmt"
     "strings"
     "time"
     "os"
     "bufio"
+    "errors"
     "gvm/vm"
+    "gvm/debugger"
 )
 
 func hello() {
@@ -18,20 +29,81 @@ func hello() {
         fmt.Printf("\r[%-10s] %d%% Complete", strings.Repeat("#", i/10), i)
         time.Sleep(150 * time.Millisecond)
     }
-    fmt.Printf("\nWelcome! Use 'run [filename]' to execute a Susan program or EXIT to exit.\n")
+    fmt.Printf("\nWelcome! Use 'run [filename]' to execute a Susan program, 'debug [filename]' to debug it, 'disasm [filename]' to disassemble it, or EXIT to exit.\n")
     return
 }
 
+// lineReader reads from a buffered reader at most one line at a time. A
+// bufio.Scanner over a lineReader never reads past the line it returns,
+// so the REPL's scanner and the programs it runs can share one reader 
+// (e.g., 'run in.sus' followed by the program's input on a pipe). 
+type lineReader struct {
+    in *bufio.Reader
+}
+
+func (r lineReader) Read(p []byte) (int, error) {
+    n := 0
+    for n < len(p) {
+        c, err := r.in.ReadByte()
+        if err != nil {
+            if n > 0 {
+                return n, nil
+            }
+            return 0, err
+        }
+        p[n] = c
+        n++
+        if c == '\n' {
+            break
+        }
+    }
+    return n, nil
+}
+
+// debug loads the program file into the virtual machine and hands the
+// REPL's input over to the debugger until the user quits it. 
+func debug(virtualMachine *vm.VirtualMachine, filename string, scanner *bufio.Scanner) error {
+    sourceCode, err := os.Open(filename)
+    if err != nil {
+        return fmt.Errorf("gvm: debug: failed to open file: '%s'",filename)
+    }
+    defer sourceCode.Close()
+    if err := virtualMachine.Load(sourceCode); err != nil {
+        return err
+    }
+    return debugger.New(virtualMachine, scanner, os.Stdout).Run()
+}
+
+// disassemble loads the program file, either source code or an object 
+// file, and prints its disassembly. 
+func disassemble(virtualMachine *vm.VirtualMachine, filename string) error {
+    err := loadFile(filename, func(r io.Reader) error {
+        return load(virtualMachine, r)
+    })
+    if err != nil {
+        return err
+    }
+    return virtualMachine.Disassemble(os.Stdout)
+}
+
 func main() {
 
+    // commands run without the banner or the prompt 
+    if len(os.Args) > 1 {
+        os.Exit(command(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+    }
+
     hello()
-    scanner := bufio.NewScanner(os.Stdin)
+    // the REPL's commands and the programs it runs read the same input 
+    stdin := bufio.NewReader(os.Stdin)
+    scanner := bufio.NewScanner(lineReader{stdin})
+    status := 0 // exit status of the last program run
 
     // this code section prompts for filename of program user wishes to run 
     for {
         fmt.Print(">> ")
         if !scanner.Scan() {
-            fmt.Println("gvm: error reading from STDIN channel: exiting program.\n")
+            fmt.Println("gvm: error reading from STDIN channel: exiting program.")
             break // EOF or error
         }
         input := scanner.Text()
@@ -45,11 +117,24 @@ func main() {
         if strings.EqualFold(parts[0], "EXIT") {
             break
         }
-        if parts[0] != "run" {
-            fmt.Printf("gvm: invalid input: use 'run [filename]' to execute program or EXIT to exit.\n")
+        if parts[0] != "run" && parts[0] != "debug" && parts[0] != "disasm" {
+            fmt.Printf("gvm: invalid input: use 'run [filename]' to execute program, 'debug [filename]' to debug program, 'disasm [filename]' to disassemble program or EXIT to exit.\n")
             continue
         }
-        // if we're here, then parts[0] is run
+        // if we're here, then parts[0] is run, debug or disasm
+        traceFormat := ""
+        if len(parts) > 1 && parts[0] == "run" && strings.HasPrefix(parts[1], "--trace") {
+            switch parts[1] {
+            case "--trace":
+                traceFormat = vm.TRACE_TEXT
+            case "--trace=" + vm.TRACE_TEXT, "--trace=" + vm.TRACE_JSON:
+                traceFormat = strings.TrimPrefix(parts[1], "--trace=")
+            default:
+                fmt.Printf("gvm: invalid trace option '%s': use --trace, --trace=text or --trace=json\n",parts[1])
+                continue
+            }
+            parts = append(parts[:1], parts[2:]...)
+        }
         if len(parts) < 2 {
             fmt.Printf("gvm: missing filename\n")
             continue 
@@ -70,9 +155,34 @@ func main() {
         }
         // if we're here, we have a valid file and can initialize the VM and 
         // execute the source program 
-        vm := vm.NewVirtualMachine()
-        if err := vm.Execute(filename); err != nil {
+        virtualMachine := vm.NewVirtualMachine(os.Stdout)
+        virtualMachine.Interpreter.Input = stdin
+        if parts[0] == "debug" {
+            if err := debug(virtualMachine, filename, scanner); err != nil {
+                fmt.Printf("%v\n",err)
+            }
+            continue
+        }
+        if parts[0] == "disasm" {
+            if err := disassemble(virtualMachine, filename); err != nil {
+                fmt.Printf("%v\n",err)
+            }
+            continue
+        }
+        if traceFormat != "" {
+            virtualMachine.Trace = os.Stderr
+            virtualMachine.TraceFormat = traceFormat
+        }
+        status = 0
+        if err := virtualMachine.Execute(filename); err != nil {
             fmt.Printf("%v\n",err)
+            var exitErr *vm.ExitError
+            if errors.As(err, &exitErr) {
+                status = exitStatus(exitErr.Status)
+            } else {
+                status = EXIT_FAILED
+            }
         }
      }
+     os.Exit(status)
 }
@@ -0,0 +1,28 @@
+package main
+
+import (
+    "bufio"
+    "strings"
+    "testing"
+    "gvm/vm"
+)
+
+// Tests that the REPL's scanner leaves the input after a command to the
+// program the command runs.
+func TestLineReader(t *testing.T) {
+    stdin := bufio.NewReader(strings.NewReader("run in.sus\n42\nexit\n"))
+    scanner := bufio.NewScanner(lineReader{stdin})
+    if !scanner.Scan() || scanner.Text() != "run in.sus" {
+        t.Fatalf("FAIL: expected 'run in.sus', got %q: %v", scanner.Text(), scanner.Err())
+    }
+
+    var output strings.Builder
+    virtualMachine := vm.NewVirtualMachine(&output)
+    virtualMachine.Interpreter.Input = stdin
+    if err := virtualMachine.ExecuteString("STDIN r1\nPRINTD r1\n"); err != nil || output.String() != "42" {
+        t.Errorf("FAIL: expected output 42, got %q: %v", output.String(), err)
+    }
+    if !scanner.Scan() || scanner.Text() != "exit" {
+        t.Errorf("FAIL: expected 'exit', got %q: %v", scanner.Text(), scanner.Err())
+    }
+}
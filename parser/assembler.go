@@ -0,0 +1,254 @@
+package parser
+
+import (
+    "io"
+    "fmt"
+    "bufio"
+    "errors"
+    "sort"
+    "gvm/token"
+    "gvm/instructions"
+)
+
+// MAX_ERRORS is the default number of syntax errors collected before the
+// assembler gives up on a program. 
+const MAX_ERRORS = 10
+
+// Assembler translates a Susan program into bytecode in two passes. 
+//
+// The first pass, DefineLabels, records the address of every label 
+// definition in the program so that jumps may refer to labels defined 
+// later in the source. Blank lines, comment lines and lines holding only
+// a label definition do not occupy an address, so instruction addresses
+// are counted over real instructions only. The second pass, Assemble, 
+// parses each line with a Parser which resolves label references using
+// the label table built in the first pass. 
+//
+// The assembler does not stop at the first syntax error. Every error 
+// found in either pass is recorded so that all of the errors in a 
+// program can be reported at once. 
+type Assembler struct {
+    // Labels maps each label name to the address of the instruction
+    // which follows its definition. 
+    Labels map[string]int32
+
+    // SourceMap holds, for each assembled instruction address, the 
+    // instruction token where the instruction begins in the source code.
+    // It is used to locate errors which occur at runtime. 
+    SourceMap []token.Token
+
+    // Strings is the string table holding the string literals of the 
+    // program's PRINT instructions. 
+    Strings []string
+
+    // Errors holds the errors recorded so far, and MaxErrors is the 
+    // number of errors after which assembly should stop. A MaxErrors 
+    // value <= 0 means there is no limit. 
+    Errors token.ErrorList
+    MaxErrors int
+
+    // MaxCodeSize is the number of instructions a program may hold. 
+    // Assembly stops with a program too large error at the first 
+    // instruction beyond it. A value <= 0 means there is no limit. 
+    MaxCodeSize int
+
+    // line is the last line number passed to Assemble, and size is the 
+    // number of instructions found by Assemble so far. 
+    line int
+    size int
+}
+
+// NewAssembler initializes an assembler with an empty label table. 
+func NewAssembler() *Assembler {
+    return &Assembler{Labels: make(map[string]int32), MaxErrors: MAX_ERRORS}
+}
+
+// Report records an error found during assembly. Errors without a 
+// source position are recorded at line 0. 
+func (a *Assembler) Report(err error) {
+    var sourceErr *token.SourceError
+    if !errors.As(err, &sourceErr) {
+        sourceErr = token.NewError(0, 0, err)
+    }
+    a.Errors = append(a.Errors, sourceErr)
+}
+
+// Full reports whether assembly may stop: either the program has more 
+// than MaxCodeSize instructions, or more than MaxErrors errors have been
+// recorded on the lines assembled so far, so the errors on the remaining
+// lines would all be cut from the list returned by Err. 
+func (a *Assembler) Full() bool {
+    if a.tooLarge() {
+        return true
+    }
+    if a.MaxErrors <= 0 {
+        return false
+    }
+    count := 0
+    for _, err := range a.Errors {
+        if err.Line <= a.line {
+            count++
+        }
+    }
+    return count > a.MaxErrors
+}
+
+func (a *Assembler) tooLarge() bool {
+    return a.MaxCodeSize > 0 && a.size > a.MaxCodeSize
+}
+
+// Err returns the recorded errors, sorted by source position, as a 
+// single ErrorList error. If no errors were recorded, nil is returned. 
+// If more than MaxErrors errors were recorded, only the first MaxErrors
+// are returned, followed by a token.ErrTooManyErrors entry at the 
+// position of the first error left out. 
+func (a *Assembler) Err() error {
+    if len(a.Errors) == 0 {
+        return nil
+    }
+    sort.SliceStable(a.Errors, func(i, j int) bool {
+        if a.Errors[i].Line != a.Errors[j].Line {
+            return a.Errors[i].Line < a.Errors[j].Line
+        }
+        return a.Errors[i].Column < a.Errors[j].Column
+    })
+    if a.MaxErrors <= 0 || len(a.Errors) <= a.MaxErrors {
+        return a.Errors
+    }
+    next := a.Errors[a.MaxErrors]
+    errs := append(token.ErrorList{}, a.Errors[:a.MaxErrors]...)
+    tooMany := fmt.Errorf("%w (stopping after %d)",token.ErrTooManyErrors,a.MaxErrors)
+    return append(errs, token.NewError(next.Line, next.Column, tooMany))
+}
+
+// DefineLabels is the first assembly pass. Each line is scanned for a 
+// label definition and the label is bound to the address of the next 
+// instruction. A line containing no instruction (blank, comment only
+// or label only) does not occupy an address. Syntax errors are ignored
+// here as they are reported by the second pass. If a label is defined 
+// more than once, then a duplicate label error is recorded. The errors
+// recorded so far are returned. 
+func (a *Assembler) DefineLabels(lines []string) error {
+    var addr int32 = 0
+    for i, line := range lines {
+        if a.MaxCodeSize > 0 && addr > int32(a.MaxCodeSize) {
+            break // the program is too large; reported by the second pass
+        }
+        p, err := NewAt(line, i + 1)
+        if err != nil {
+            addr++
+            continue
+        }
+        labelToken := p.CurrentToken
+        name, err := p.Label()
+        if name != "" {
+            if prev, ok := a.Labels[name]; ok {
+                a.Report(p.Errorf(labelToken, "gvm: duplicate label: '%s' (already defined at addr %d)",name,prev))
+            } else {
+                a.Labels[name] = addr
+            }
+        }
+        if err == nil && p.CurrentToken.TokenType == token.EOF {
+            continue // no instruction on this line 
+        }
+        addr++
+    }
+    return a.Err()
+}
+
+// Assemble is the second assembly pass. It parses a single line of source
+// code, found at line number lineNo, into a bytecode instruction, 
+// resolving any label references. If the line contains no instruction
+// (blank, comment only or label only), then a nil instruction and nil 
+// error are returned. Otherwise, the source position of the instruction
+// is recorded in the SourceMap. Any token after a complete instruction is
+// a syntax error. Any error is both recorded and returned. 
+func (a *Assembler) Assemble(lineNo int, line string) (instructions.Instruction, error) {
+    a.line = lineNo
+    instr, err := a.assemble(lineNo, line)
+    if err != nil {
+        a.Report(err)
+    }
+    return instr, err
+}
+
+func (a *Assembler) assemble(lineNo int, line string) (instructions.Instruction, error) {
+    p, err := NewAt(line, lineNo)
+    if err != nil {
+        return instructions.NewError(err), err
+    }
+    p.Labels = a.Labels
+    p.Strings = a.Strings
+    if _, err := p.Label(); err != nil {
+        return instructions.NewError(err), err
+    }
+    if p.CurrentToken.TokenType == token.EOF {
+        return nil, nil
+    }
+    position := *p.CurrentToken
+    a.size++
+    if a.tooLarge() {
+        err := p.Errorf(&position, "gvm: program too large: exceeds %d instructions",a.MaxCodeSize)
+        return instructions.NewError(err), err
+    }
+    instr, err := p.Instruction()
+    if err != nil {
+        return instr, err
+    }
+    if p.CurrentToken.TokenType != token.EOF {
+        // e.g., NOP NOP or LDI r1, 5 6
+        err := p.Errorf(p.CurrentToken, "gvm: syntax error: unexpected %s",p.CurrentToken.TokenType)
+        return instructions.NewError(err), err
+    }
+    a.SourceMap = append(a.SourceMap, position)
+    a.Strings = p.Strings
+    return instr, nil
+}
+
+// AssembleLines assembles the lines of a program in both passes and 
+// returns its bytecode instructions. Assembly continues past syntax 
+// errors, so every error in the program is reported, until the 
+// assembler is Full. The program's labels, string table and source map
+// are held in the assembler, and any errors are returned as by Err. 
+func (a *Assembler) AssembleLines(lines []string) ([]instructions.Instruction, error) {
+    a.DefineLabels(lines) // errors are reported after the second pass
+    var code []instructions.Instruction
+    for i, line := range lines {
+        if a.Full() {
+            break
+        }
+        instr, err := a.Assemble(i + 1, line)
+        if err == nil && instr != nil {
+            code = append(code, instr)
+        }
+    }
+    return code, a.Err()
+}
+
+// AssembleReader reads the lines of a program from r and assembles them
+// as AssembleLines does. The lines are returned with the bytecode so 
+// that errors can be shown with the source line at which they occur. 
+func (a *Assembler) AssembleReader(r io.Reader) ([]string, []instructions.Instruction, error) {
+    var lines []string
+    scanner := bufio.NewScanner(r)
+    for scanner.Scan() {
+        lines = append(lines, scanner.Text())
+    }
+    if err := scanner.Err(); err != nil {
+        return lines, nil, fmt.Errorf("gvm: failed to read program: %v",err)
+    }
+    code, err := a.AssembleLines(lines)
+    return lines, code, err
+}
+
+// Assemble reads a Susan program from r and assembles it with a new 
+// Assembler. It returns the program's bytecode instructions, string 
+// table and labels, or the syntax errors found in the program. 
+func Assemble(r io.Reader) ([]instructions.Instruction, []string, map[string]int32, error) {
+    assembler := NewAssembler()
+    _, code, err := assembler.AssembleReader(r)
+    if err != nil {
+        return nil, nil, nil, err
+    }
+    return code, assembler.Strings, assembler.Labels, nil
+}
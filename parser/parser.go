@@ -4,7 +4,7 @@
 // The parser verifies the syntax of the current instruction and, if 
 // no syntax errors are detected, creates a bytecode representation of the 
 // instruction using the data structure Instruction defined in the 
-// 'instruction' package along with the opcodes listed below. 
+// 'instructions' package along with the opcodes defined there. 
 //
 // If a syntax error is detected by the parser or propagated from a return
 // from the 'lexer' package, then the error is propagated to the 'vm'
@@ -18,31 +18,41 @@ import (
     "gvm/instructions"
 )
 
-const (
-    OPCODE_STDOUT = 0x00
-    OPCODE_LDI    = 0x01
-    OPCODE_JUMP   = 0x02
-    OPCODE_ADD    = 0x17
-    OPCODE_ADDV   = 0x18
-    OPCODE_DRAW   = 0x19
-    OPCODE_BLINK  = 0x20
-    OPCODE_PRINTR = 0x21
-)
-
 type Parser struct {
     Lex *lexer.Lexer
     CurrentToken *token.Token
+
+    // Labels maps label names to instruction addresses. It is used to 
+    // resolve label references (e.g., JUMP loop) into addresses. 
+    Labels map[string]int32
+
+    // Strings is the string table of the program. The string literal of a
+    // PRINT instruction is appended to it and the instruction refers to 
+    // the string by its index. 
+    Strings []string
 }
 
 // Initialize a parser with a lexer, giving it as an argument the current 
 // instruction to be parsed in the user's source code, and set the 
 // current token as the first token in the input returned from the lexer. 
 func New(input string) (*Parser, error) {
-    lex := lexer.New(input)
+    return NewAt(input, 1)
+}
+
+// NewAt initializes a parser for an instruction found at the given line
+// number of the user's source code so that errors can be located. 
+func NewAt(input string, line int) (*Parser, error) {
+    lex := lexer.NewAt(input, line)
     currentToken, err := lex.GetNextToken()
     return &Parser{Lex: lex, CurrentToken: currentToken}, err
 }
 
+// Errorf creates an error annotated with the line and column of the 
+// token tok. 
+func (p *Parser) Errorf(tok *token.Token, format string, args ...interface{}) error {
+    return token.NewError(tok.Line, tok.Column, fmt.Errorf(format, args...))
+}
+
 // Consume ensures that the token type of the current token being parsed
 // is consistent with the expected type according to the syntax of the 
 // language. If the types match, then Consume attempts to get the next
@@ -52,7 +62,7 @@ func New(input string) (*Parser, error) {
 // the input to be parsed. 
 func (p *Parser) Consume(expectedType string) error {
     if p.CurrentToken.TokenType != expectedType {
-        return fmt.Errorf("gvm: syntax error: unexpected %s", p.CurrentToken.TokenType)
+        return p.Errorf(p.CurrentToken, "gvm: syntax error: unexpected %s", p.CurrentToken.TokenType)
     }
     var err error
     p.CurrentToken, err = p.Lex.GetNextToken()
@@ -62,6 +72,155 @@ func (p *Parser) Consume(expectedType string) error {
     return nil
 }
 
+// Label consumes the label definition at the start of the current line,
+// if one is present, and returns the label name. If the line does not
+// begin with a label definition, an empty string is returned. 
+func (p *Parser) Label() (string, error) {
+    if p.CurrentToken.TokenType != token.LABEL {
+        return "", nil
+    }
+    name := p.CurrentToken.Literal
+    if err := p.Consume(token.LABEL); err != nil {
+        return name, err
+    }
+    return name, nil
+}
+
+// Address obtains the address operand of a jump instruction. The address
+// is either an INT literal or an IDENT label reference, which is resolved 
+// using the parser's label table. An error is returned if the label was 
+// never defined. 
+func (p *Parser) Address() (int32, error) {
+    currentToken := p.CurrentToken
+    if currentToken.TokenType == token.IDENT {
+        if err := p.Consume(token.IDENT); err != nil {
+            return 0, err
+        }
+        addr, ok := p.Labels[currentToken.Literal]
+        if !ok {
+            return 0, p.Errorf(currentToken, "gvm: undefined label: '%s'",currentToken.Literal)
+        }
+        return addr, nil
+    }
+    if err := p.Consume(token.INT); err != nil {
+        return 0, err
+    }
+    return currentToken.Value, nil
+}
+
+// RegisterRegister parses the operands of an instruction of the form 
+// INSTR REG COMMA REG, where the instruction token has already been 
+// consumed, and returns a BinaryInstruction with the given opcode. 
+func (p *Parser) RegisterRegister(opcode int32) (instructions.Instruction, error) {
+    // REG - destination register 
+    currentToken := p.CurrentToken
+    if err := p.Consume(token.REG); err != nil {
+        return instructions.NewError(err), err
+    }
+    regIndex1 := currentToken.Value
+
+    // COMMA
+    if err := p.Consume(token.COMMA); err != nil {
+        return instructions.NewError(err), err
+    }
+    // REG - source register 
+    currentToken = p.CurrentToken
+    if err := p.Consume(token.REG); err != nil {
+        return instructions.NewError(err), err
+    }
+    regIndex2 := currentToken.Value
+    return instructions.NewBinaryInstruction(opcode, regIndex1, regIndex2), nil
+}
+
+// RegisterImmediate parses the operands of an instruction of the form 
+// INSTR REG COMMA INT, where the instruction token has already been 
+// consumed, and returns a BinaryInstruction with the given opcode. 
+func (p *Parser) RegisterImmediate(opcode int32) (instructions.Instruction, error) {
+    // REG - destination register 
+    currentToken := p.CurrentToken
+    if err := p.Consume(token.REG); err != nil {
+        return instructions.NewError(err), err
+    }
+    regIndex := currentToken.Value
+
+    // COMMA
+    if err := p.Consume(token.COMMA); err != nil {
+        return instructions.NewError(err), err
+    }
+    // INT - immediate value 
+    currentToken = p.CurrentToken
+    if err := p.Consume(token.INT); err != nil {
+        return instructions.NewError(err), err
+    }
+    return instructions.NewBinaryInstruction(opcode, regIndex, currentToken.Value), nil
+}
+
+// RegisterOrImmediate parses the operands of an instruction of the form
+// INSTR REG COMMA REG or INSTR REG COMMA INT, where the instruction token
+// has already been consumed. It returns a BinaryInstruction with opcode 
+// regOpcode if the second operand is a register, or immOpcode if it is 
+// an immediate value. 
+func (p *Parser) RegisterOrImmediate(regOpcode, immOpcode int32) (instructions.Instruction, error) {
+    // REG - destination register 
+    currentToken := p.CurrentToken
+    if err := p.Consume(token.REG); err != nil {
+        return instructions.NewError(err), err
+    }
+    regIndex := currentToken.Value
+
+    // COMMA
+    if err := p.Consume(token.COMMA); err != nil {
+        return instructions.NewError(err), err
+    }
+    // REG or INT 
+    currentToken = p.CurrentToken
+    if currentToken.TokenType == token.REG {
+        if err := p.Consume(token.REG); err != nil {
+            return instructions.NewError(err), err
+        }
+        return instructions.NewBinaryInstruction(regOpcode, regIndex, currentToken.Value), nil
+    }
+    if err := p.Consume(token.INT); err != nil {
+        return instructions.NewError(err), err
+    }
+    return instructions.NewBinaryInstruction(immOpcode, regIndex, currentToken.Value), nil
+}
+
+// Register parses the operand of an instruction of the form INSTR REG, 
+// where the instruction token has already been consumed, and returns a 
+// UnaryInstruction with the given opcode. 
+func (p *Parser) Register(opcode int32) (instructions.Instruction, error) {
+    currentToken := p.CurrentToken
+    if err := p.Consume(token.REG); err != nil {
+        return instructions.NewError(err), err
+    }
+    return instructions.NewUnaryInstruction(opcode, currentToken.Value), nil
+}
+
+// MemoryAddress parses a data memory operand of the form LBRACKET REG 
+// RBRACKET or LBRACKET INT RBRACKET. It returns the register index or 
+// the address, and whether the address is held in a register. 
+func (p *Parser) MemoryAddress() (int32, bool, error) {
+    if err := p.Consume(token.LBRACKET); err != nil {
+        return 0, false, err
+    }
+    currentToken := p.CurrentToken
+    indirect := currentToken.TokenType == token.REG
+    if indirect {
+        if err := p.Consume(token.REG); err != nil {
+            return 0, false, err
+        }
+    } else {
+        if err := p.Consume(token.INT); err != nil {
+            return 0, false, err
+        }
+    }
+    if err := p.Consume(token.RBRACKET); err != nil {
+        return 0, false, err
+    }
+    return currentToken.Value, indirect, nil
+}
+
 // Instruction creates bytecode instructions from a stream of tokens
 // as they are parsed. If the current token type is a specific 
 // instruction, then Instruction checks that the instruction syntax
@@ -84,16 +243,15 @@ func (p *Parser) Instruction() (instructions.Instruction, error) {
     switch currentToken.TokenType {
 
     case token.JUMP: 
-        // JUMP INT (address)
+        // JUMP INT (address) or JUMP IDENT (label)
         if err := p.Consume(token.JUMP); err != nil {
             return instructions.NewError(err), err
         }
-        currentToken = p.CurrentToken
-        if err := p.Consume(token.INT); err != nil {
+        jumpTo, err := p.Address()
+        if err != nil {
             return instructions.NewError(err), err
         }
-        jumpTo := currentToken.Value
-        return instructions.NewUnaryInstruction(int32(OPCODE_JUMP), jumpTo), nil
+        return instructions.NewUnaryInstruction(int32(instructions.OPCODE_JUMP), jumpTo), nil
 
     case token.LDI:
         // LDI REG COMMA INT
@@ -119,7 +277,7 @@ func (p *Parser) Instruction() (instructions.Instruction, error) {
         loadValue := currentToken.Value
 
         // return LDI instruction bytecode 
-        return instructions.NewBinaryInstruction(int32(OPCODE_LDI),regIndex,loadValue), nil
+        return instructions.NewBinaryInstruction(int32(instructions.OPCODE_LDI),regIndex,loadValue), nil
     
     case token.ADD: 
         // ADD REG COMMA REG 
@@ -145,7 +303,7 @@ func (p *Parser) Instruction() (instructions.Instruction, error) {
         regIndex2 := currentToken.Value
         
         // Return ADD instruction bytecode 
-        add_instr := instructions.NewBinaryInstruction(int32(OPCODE_ADD), regIndex1, regIndex2)
+        add_instr := instructions.NewBinaryInstruction(int32(instructions.OPCODE_ADD), regIndex1, regIndex2)
         return add_instr, nil
 
     case token.ADDV:
@@ -171,7 +329,7 @@ func (p *Parser) Instruction() (instructions.Instruction, error) {
         }
         regIndex2 := currentToken.Value
 
-        addv_instr := instructions.NewBinaryInstruction(int32(OPCODE_ADDV),regIndex1,regIndex2)
+        addv_instr := instructions.NewBinaryInstruction(int32(instructions.OPCODE_ADDV),regIndex1,regIndex2)
         return addv_instr, nil
 
     case token.DRAW:
@@ -184,7 +342,7 @@ func (p *Parser) Instruction() (instructions.Instruction, error) {
             return instructions.NewError(err), err
         }
         shape := currentToken.Value
-        return instructions.NewUnaryInstruction(int32(OPCODE_DRAW),shape), nil
+        return instructions.NewUnaryInstruction(int32(instructions.OPCODE_DRAW),shape), nil
 
     case token.BLINK:
         // BLINK SHAPE
@@ -199,7 +357,7 @@ func (p *Parser) Instruction() (instructions.Instruction, error) {
         shape := currentToken.Value
         
         // Return BLINK bytecode instruction
-        return instructions.NewUnaryInstruction(int32(OPCODE_BLINK),shape), nil
+        return instructions.NewUnaryInstruction(int32(instructions.OPCODE_BLINK),shape), nil
     
     case token.STDOUT:
         // STDOUT REG
@@ -214,16 +372,258 @@ func (p *Parser) Instruction() (instructions.Instruction, error) {
         regIndex := currentToken.Value
 
         // Return STDOUT bytecode instruction
-        return instructions.NewUnaryInstruction(int32(OPCODE_STDOUT), regIndex), nil
+        return instructions.NewUnaryInstruction(int32(instructions.OPCODE_STDOUT), regIndex), nil
 
     case token.PRINTR:
         if err := p.Consume(token.PRINTR); err != nil {
             return instructions.NewError(err), err
         }
-        return instructions.NewNullaryInstruction(int32(OPCODE_PRINTR)),nil
+        return instructions.NewNullaryInstruction(int32(instructions.OPCODE_PRINTR)),nil
+
+    case token.SUB, token.MUL, token.DIV, token.MOD:
+        // SUB|MUL|DIV|MOD REG COMMA REG
+        opcodes := map[string]int32{
+            token.SUB: instructions.OPCODE_SUB,
+            token.MUL: instructions.OPCODE_MUL,
+            token.DIV: instructions.OPCODE_DIV,
+            token.MOD: instructions.OPCODE_MOD,
+        }
+        if err := p.Consume(currentToken.TokenType); err != nil {
+            return instructions.NewError(err), err
+        }
+        return p.RegisterRegister(opcodes[currentToken.TokenType])
+
+    case token.CMP:
+        // CMP REG COMMA REG
+        if err := p.Consume(token.CMP); err != nil {
+            return instructions.NewError(err), err
+        }
+        return p.RegisterRegister(int32(instructions.OPCODE_CMP))
+
+    case token.JEQ, token.JNE, token.JLT, token.JGT, token.JLE, token.JGE:
+        // JEQ|JNE|JLT|JGT|JLE|JGE INT (address) or IDENT (label)
+        opcodes := map[string]int32{
+            token.JEQ: instructions.OPCODE_JEQ,
+            token.JNE: instructions.OPCODE_JNE,
+            token.JLT: instructions.OPCODE_JLT,
+            token.JGT: instructions.OPCODE_JGT,
+            token.JLE: instructions.OPCODE_JLE,
+            token.JGE: instructions.OPCODE_JGE,
+        }
+        if err := p.Consume(currentToken.TokenType); err != nil {
+            return instructions.NewError(err), err
+        }
+        jumpTo, err := p.Address()
+        if err != nil {
+            return instructions.NewError(err), err
+        }
+        return instructions.NewUnaryInstruction(opcodes[currentToken.TokenType], jumpTo), nil
+
+    case token.CALL:
+        // CALL INT (address) or CALL IDENT (label)
+        if err := p.Consume(token.CALL); err != nil {
+            return instructions.NewError(err), err
+        }
+        callTo, err := p.Address()
+        if err != nil {
+            return instructions.NewError(err), err
+        }
+        return instructions.NewUnaryInstruction(int32(instructions.OPCODE_CALL), callTo), nil
+
+    case token.RET:
+        // RET
+        if err := p.Consume(token.RET); err != nil {
+            return instructions.NewError(err), err
+        }
+        return instructions.NewNullaryInstruction(int32(instructions.OPCODE_RET)), nil
+
+    case token.LD:
+        // LD REG COMMA LBRACKET (REG|INT) RBRACKET
+        if err := p.Consume(token.LD); err != nil {
+            return instructions.NewError(err), err
+        }
+        // REG - load to 
+        currentToken = p.CurrentToken
+        if err := p.Consume(token.REG); err != nil {
+            return instructions.NewError(err), err
+        }
+        regIndex := currentToken.Value
+
+        // COMMA
+        if err := p.Consume(token.COMMA); err != nil {
+            return instructions.NewError(err), err
+        }
+        // [REG] or [INT] - load from 
+        addr, indirect, err := p.MemoryAddress()
+        if err != nil {
+            return instructions.NewError(err), err
+        }
+        if indirect {
+            return instructions.NewBinaryInstruction(int32(instructions.OPCODE_LD), regIndex, addr), nil
+        }
+        return instructions.NewBinaryInstruction(int32(instructions.OPCODE_LDA), regIndex, addr), nil
+
+    case token.ST:
+        // ST LBRACKET (REG|INT) RBRACKET COMMA REG
+        if err := p.Consume(token.ST); err != nil {
+            return instructions.NewError(err), err
+        }
+        // [REG] or [INT] - store to 
+        addr, indirect, err := p.MemoryAddress()
+        if err != nil {
+            return instructions.NewError(err), err
+        }
+        // COMMA
+        if err := p.Consume(token.COMMA); err != nil {
+            return instructions.NewError(err), err
+        }
+        // REG - store from 
+        currentToken = p.CurrentToken
+        if err := p.Consume(token.REG); err != nil {
+            return instructions.NewError(err), err
+        }
+        regIndex := currentToken.Value
+        if indirect {
+            return instructions.NewBinaryInstruction(int32(instructions.OPCODE_ST), addr, regIndex), nil
+        }
+        return instructions.NewBinaryInstruction(int32(instructions.OPCODE_STA), addr, regIndex), nil
+
+    case token.PUSH:
+        // PUSH REG
+        if err := p.Consume(token.PUSH); err != nil {
+            return instructions.NewError(err), err
+        }
+        return p.Register(int32(instructions.OPCODE_PUSH))
+
+    case token.POP:
+        // POP REG
+        if err := p.Consume(token.POP); err != nil {
+            return instructions.NewError(err), err
+        }
+        return p.Register(int32(instructions.OPCODE_POP))
+
+    case token.AND, token.OR, token.XOR:
+        // AND|OR|XOR REG COMMA REG
+        opcodes := map[string]int32{
+            token.AND: instructions.OPCODE_AND,
+            token.OR: instructions.OPCODE_OR,
+            token.XOR: instructions.OPCODE_XOR,
+        }
+        if err := p.Consume(currentToken.TokenType); err != nil {
+            return instructions.NewError(err), err
+        }
+        return p.RegisterRegister(opcodes[currentToken.TokenType])
+
+    case token.NOT:
+        // NOT REG
+        if err := p.Consume(token.NOT); err != nil {
+            return instructions.NewError(err), err
+        }
+        return p.Register(int32(instructions.OPCODE_NOT))
+
+    case token.SHL, token.SHR, token.SAR:
+        // SHL|SHR|SAR REG COMMA REG or SHL|SHR|SAR REG COMMA INT
+        opcodes := map[string][2]int32{
+            token.SHL: {instructions.OPCODE_SHL, instructions.OPCODE_SHLI},
+            token.SHR: {instructions.OPCODE_SHR, instructions.OPCODE_SHRI},
+            token.SAR: {instructions.OPCODE_SAR, instructions.OPCODE_SARI},
+        }
+        if err := p.Consume(currentToken.TokenType); err != nil {
+            return instructions.NewError(err), err
+        }
+        opcode := opcodes[currentToken.TokenType]
+        return p.RegisterOrImmediate(opcode[0], opcode[1])
+
+    case token.MOV:
+        // MOV REG COMMA REG
+        if err := p.Consume(token.MOV); err != nil {
+            return instructions.NewError(err), err
+        }
+        return p.RegisterRegister(int32(instructions.OPCODE_MOV))
+
+    case token.ADDI, token.SUBI, token.MULI, token.DIVI, token.MODI, token.ANDI, token.ORI, token.XORI:
+        // ADDI|SUBI|MULI|DIVI|MODI|ANDI|ORI|XORI REG COMMA INT
+        opcodes := map[string]int32{
+            token.ADDI: instructions.OPCODE_ADDI,
+            token.SUBI: instructions.OPCODE_SUBI,
+            token.MULI: instructions.OPCODE_MULI,
+            token.DIVI: instructions.OPCODE_DIVI,
+            token.MODI: instructions.OPCODE_MODI,
+            token.ANDI: instructions.OPCODE_ANDI,
+            token.ORI: instructions.OPCODE_ORI,
+            token.XORI: instructions.OPCODE_XORI,
+        }
+        if err := p.Consume(currentToken.TokenType); err != nil {
+            return instructions.NewError(err), err
+        }
+        return p.RegisterImmediate(opcodes[currentToken.TokenType])
+
+    case token.HALT:
+        // HALT or HALT REG (exit status)
+        if err := p.Consume(token.HALT); err != nil {
+            return instructions.NewError(err), err
+        }
+        if p.CurrentToken.TokenType == token.EOF {
+            return instructions.NewNullaryInstruction(int32(instructions.OPCODE_HALT)), nil
+        }
+        return p.Register(int32(instructions.OPCODE_HALTR))
+
+    case token.NOP:
+        // NOP
+        if err := p.Consume(token.NOP); err != nil {
+            return instructions.NewError(err), err
+        }
+        return instructions.NewNullaryInstruction(int32(instructions.OPCODE_NOP)), nil
+
+    case token.STDIN:
+        // STDIN REG
+        if err := p.Consume(token.STDIN); err != nil {
+            return instructions.NewError(err), err
+        }
+        return p.Register(int32(instructions.OPCODE_STDIN))
+
+    case token.GETC:
+        // GETC REG
+        if err := p.Consume(token.GETC); err != nil {
+            return instructions.NewError(err), err
+        }
+        return p.Register(int32(instructions.OPCODE_GETC))
+
+    case token.PUTC, token.PRINTD, token.PRINTX, token.PRINTB:
+        // PUTC|PRINTD|PRINTX|PRINTB REG
+        opcodes := map[string]int32{
+            token.PUTC: instructions.OPCODE_PUTC,
+            token.PRINTD: instructions.OPCODE_PRINTD,
+            token.PRINTX: instructions.OPCODE_PRINTX,
+            token.PRINTB: instructions.OPCODE_PRINTB,
+        }
+        if err := p.Consume(currentToken.TokenType); err != nil {
+            return instructions.NewError(err), err
+        }
+        return p.Register(opcodes[currentToken.TokenType])
+
+    case token.PRINT:
+        // PRINT STRING
+        if err := p.Consume(token.PRINT); err != nil {
+            return instructions.NewError(err), err
+        }
+        currentToken = p.CurrentToken
+        if err := p.Consume(token.STRING); err != nil {
+            return instructions.NewError(err), err
+        }
+        index := int32(len(p.Strings))
+        p.Strings = append(p.Strings, currentToken.Literal)
+        return instructions.NewUnaryInstruction(int32(instructions.OPCODE_PRINT), index), nil
+
+    case token.NEG:
+        // NEG REG
+        if err := p.Consume(token.NEG); err != nil {
+            return instructions.NewError(err), err
+        }
+        return p.Register(int32(instructions.OPCODE_NEG))
 
     default:
-        err := fmt.Errorf("gvm: default case: invalid '%v'",currentToken)
+        err := p.Errorf(currentToken, "gvm: default case: invalid '%v'",currentToken)
         return instructions.NewError(err),err
     }
 }
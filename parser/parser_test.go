@@ -13,7 +13,11 @@ BLINK
 package parser
 
 import (
+    "fmt"
+    "errors"
+    "strings"
     "testing"
+    "gvm/token"
 )
 
 type TestCase struct {
@@ -45,6 +49,16 @@ func TestParser(t *testing.T) {
         {" 3LDI r1,3", false},
        // {" $LDI r1,3", false},
         {"LDI r8, 89", true},
+        {"LDI r8, 89 ; load", true},
+        {"LDI r8, 89# load", true},
+        {"LDI r8, ; 89", false},
+        {"LDI r1, -5", true},
+        {"LDI r1, 0xFF", true},
+        {"LDI r1, 0b11", true},
+        {"LDI r1, 'A'", true},
+        {"LDI r1, ';' ; semicolon", true},
+        {"LDI r-1, 5", false},
+        {"LDI r0x1, 5", false},
 
 
         // JUMP INT
@@ -54,6 +68,8 @@ func TestParser(t *testing.T) {
         {"JUMP , 4", false},
         {"JUMP ", false},
         {" 4 JUMP", false},
+        {"JUMP loop", false}, // undefined label
+        {"JUMP loop:", false},
         
         // ADD REG COMMA REG 
         {"ADD r1,r2",true},
@@ -82,6 +98,147 @@ func TestParser(t *testing.T) {
         {"ADDV 1, 22", false},
         {" $ADDV r1,r2", false},
 
+        // SUB|MUL|DIV|MOD REG COMMA REG
+        {"SUB r1,r2", true},
+        {"MUL r1, r2", true},
+        {" DIV R3,  r4 ", true},
+        {"MOD r9,r1", true},
+        {"SUB r1", false},
+        {"MUL r1, 2", false},
+        {"DIV r1 r2", false},
+        {"MODr1,r2", false},
+        {"SUB , r2", false},
+
+        // CMP REG COMMA REG
+        {"CMP r1,r2", true},
+        {"CMP r1, 2", false},
+        {"CMP r1", false},
+
+        // JEQ|JNE|JLT|JGT|JLE|JGE INT
+        {"JEQ 4", true},
+        {"JNE 4", true},
+        {"JLT 4", true},
+        {"JGT 4", true},
+        {"JLE 4", true},
+        {"JGE 4", true},
+        {"JEQ r4", false},
+        {"JNE4", false},
+        {"JLT , 4", false},
+        {"JGT", false},
+
+        // CALL INT, RET
+        {"CALL 3", true},
+        {"CALL r3", false},
+        {"CALL", false},
+        {"CALL3", false},
+        {"RET", true},
+        {"  RET ; return", true},
+
+        // LD REG COMMA [REG|INT], ST [REG|INT] COMMA REG
+        {"LD r1, [r2]", true},
+        {"LD r1,[r2]", true},
+        {"LD r1, [ r2 ]", true},
+        {"LD r1, [12]", true},
+        {"LD r1, [0x10]", true},
+        {"LD r1, r2", false},
+        {"LD r1, [r2", false},
+        {"LD r1, []", false},
+        {"LD [r1], r2", false},
+        {"LD r1, [[r2]]", false},
+        {"ST [r1], r2", true},
+        {"ST [7], r2", true},
+        {"ST [r1],r2", true},
+        {"ST r1, r2", false},
+        {"ST [r1] r2", false},
+        {"ST [r1], 5", false},
+        {"ST [r1]", false},
+
+        // PUSH REG, POP REG
+        {"PUSH r1", true},
+        {"POP r9", true},
+        {"PUSH 1", false},
+        {"POP", false},
+        {"PUSHr1", false},
+        {"POP [r1]", false},
+
+        // AND|OR|XOR REG COMMA REG, NOT REG
+        {"AND r1, r2", true},
+        {"OR r1,r2", true},
+        {"XOR r3, r3", true},
+        {"AND r1, 5", false},
+        {"OR r1", false},
+        {"NOT r1", true},
+        {"NOT 1", false},
+
+        // SHL|SHR|SAR REG COMMA (REG|INT)
+        {"SHL r1, r2", true},
+        {"SHR r1, 4", true},
+        {"SAR r1, 0x1F", true},
+        {"SHL 1, r2", false},
+        {"SHR r1", false},
+        {"SAR r1 4", false},
+        {"SHL r1, loop", false},
+
+        // MOV REG COMMA REG
+        {"MOV r1, r2", true},
+        {"MOV r1, 2", false},
+        {"MOV r1", false},
+        {"MOV [r1], r2", false},
+
+        // ADDI|SUBI|MULI|DIVI|MODI|ANDI|ORI|XORI REG COMMA INT
+        {"ADDI r1, 5", true},
+        {"SUBI r1, -5", true},
+        {"MULI r1, 0x10", true},
+        {"DIVI r1, 3", true},
+        {"MODI r1, 3", true},
+        {"ANDI r1, 0b11", true},
+        {"ORI r1, 'a'", true},
+        {"XORI r1,1", true},
+        {"ADDI r1, r2", false},
+        {"SUBI 5, r1", false},
+        {"MULI r1", false},
+        {"DIVI r1 3", false},
+        {"ADDIr1, 3", false},
+
+        // HALT, HALT REG, NOP
+        {"HALT", true},
+        {"HALT r1", true},
+        {"HALT 1", false},
+        {"HALTr1", false},
+        {"NOP", true},
+        {"NOP ; nothing", true},
+
+        // STDIN REG, GETC REG
+        {"STDIN r1", true},
+        {"GETC r9", true},
+        {"STDIN", false},
+        {"GETC 1", false},
+        {"STDINr1", false},
+        {"GETC [r1]", false},
+
+        // PUTC|PRINTD|PRINTX|PRINTB REG, PRINT STRING
+        {"PUTC r1", true},
+        {"PRINTD r2", true},
+        {"PRINTX r3", true},
+        {"PRINTB r4", true},
+        {"PUTC 65", false},
+        {"PRINTX", false},
+        {"PRINTBr1", false},
+        {"PRINT \"hello, world\\n\"", true},
+        {"PRINT \"\" ; nothing", true},
+        {"PRINT \"a;b\"", true},
+        {"PRINT r1", false},
+        {"PRINT", false},
+        {"PRINT \"open", false},
+        {"PRINT\"hi\"", false},
+        {"PUTC \"a\"", false},
+
+        // NEG REG
+        {"NEG r1", true},
+        {"NEG", false},
+        {"NEG 1", false},
+        {"NEGr1", false},
+
         // DRAW SHAPE 
         {"DRAW $heart",true},
         {"DRAW $bird", true},
@@ -114,3 +271,157 @@ func TestParser(t *testing.T) {
         }
     }
 }
+
+// Tests that label references are resolved through the label table and
+// that a line may begin with a label definition. 
+func TestLabels(t *testing.T) {
+    labels := map[string]int32{"loop": 3, "end": 7}
+    testCases := []struct {
+        input string
+        addr int32
+        shouldPass bool
+    }{
+        {"JUMP loop", 3, true},
+        {"JUMP  end ", 7, true},
+        {"JUMP 5", 5, true},
+        {"JEQ loop", 3, true},
+        {"JGE end", 7, true},
+        {"JLT start", 0, false},
+        {"CALL loop", 3, true},
+        {"CALL start", 0, false},
+        {"JUMP start", 0, false},
+        {"JUMPloop", 0, false},
+        {"JUMP Loop", 0, false},
+    }
+
+    for _, testCase := range testCases {
+        parse, err := New(testCase.input)
+        if err != nil {
+            if testCase.shouldPass {
+                t.Errorf("FAIL: error returned from valid input: %s: error message: %v", testCase.input, err)
+            }
+            continue
+        }
+        parse.Labels = labels
+        instr, err := parse.Instruction()
+
+        if err == nil && !testCase.shouldPass {
+            t.Errorf("FAIL: no error returned from invalid input: %s", testCase.input)
+        }
+        if err != nil && testCase.shouldPass {
+            t.Errorf("FAIL: error returned from valid input: %s: error message: %v", testCase.input, err)
+        }
+        if err == nil && instr.GetArg1() != testCase.addr {
+            t.Errorf("FAIL: %s: expected addr %d, got %d", testCase.input, testCase.addr, instr.GetArg1())
+        }
+    }
+
+    // label definitions 
+    assembler := NewAssembler()
+    lines := []string{"start:", "LDI r1, 1", "loop: ADD r1,r1", "JUMP loop", "end:"}
+    if err := assembler.DefineLabels(lines); err != nil {
+        t.Fatalf("FAIL: error returned from valid program: %v", err)
+    }
+    for name, addr := range map[string]int32{"start": 0, "loop": 1, "end": 3} {
+        if assembler.Labels[name] != addr {
+            t.Errorf("FAIL: label %s: expected addr %d, got %d", name, addr, assembler.Labels[name])
+        }
+    }
+    if err := NewAssembler().DefineLabels([]string{"a:", "a: LDI r1, 1"}); err == nil {
+        t.Errorf("FAIL: no error returned from duplicate label")
+    }
+}
+
+// Tests that lines without an instruction produce no bytecode and that 
+// they are not counted as instruction addresses. 
+func TestBlankLines(t *testing.T) {
+    assembler := NewAssembler()
+    lines := []string{"# header", "", "LDI r1, 1 ; one", "   ", "; note", "loop:", "ADD r1,r1", "JUMP loop"}
+    if err := assembler.DefineLabels(lines); err != nil {
+        t.Fatalf("FAIL: error returned from valid program: %v", err)
+    }
+    if assembler.Labels["loop"] != 1 {
+        t.Errorf("FAIL: label loop: expected addr 1, got %d", assembler.Labels["loop"])
+    }
+    count := 0
+    for i, line := range lines {
+        instr, err := assembler.Assemble(i + 1, line)
+        if err != nil {
+            t.Errorf("FAIL: error returned from valid input: %s: error message: %v", line, err)
+        }
+        if instr != nil {
+            count++
+        }
+    }
+    if count != 3 {
+        t.Errorf("FAIL: expected 3 instructions, got %d", count)
+    }
+}
+
+// Tests that tokens after a complete instruction are reported at their
+// column rather than ignored.
+func TestTrailingTokens(t *testing.T) {
+    testCases := []struct {
+        input string
+        column int
+    }{
+        {"NOP NOP", 5},
+        {"RET r1", 5},
+        {"ADD r1, r2, r3", 11},
+        {"LDI r1, 5 6", 11},
+        {"HALT r1 r2", 9},
+        {"HALT r1, 2", 8},
+        {"loop: JUMP loop loop", 17},
+    }
+
+    for _, testCase := range testCases {
+        assembler := NewAssembler()
+        assembler.DefineLabels([]string{testCase.input})
+        _, err := assembler.Assemble(1, testCase.input)
+        if err == nil {
+            t.Errorf("FAIL: no error returned from invalid input: %s", testCase.input)
+            continue
+        }
+        if !strings.Contains(err.Error(), fmt.Sprintf("1:%d:", testCase.column)) || !strings.Contains(err.Error(), "unexpected") {
+            t.Errorf("FAIL: %s: expected an unexpected token error at column %d, got: %v", testCase.input, testCase.column, err)
+        }
+        if len(assembler.SourceMap) != 0 {
+            t.Errorf("FAIL: %s: invalid instruction recorded in the SourceMap", testCase.input)
+        }
+    }
+}
+
+// Tests that Assemble returns the bytecode, string table and labels of a
+// program, or its syntax errors.
+func TestAssemble(t *testing.T) {
+    code, strs, labels, err := Assemble(strings.NewReader("loop: PRINT \"hi\"\n\nJUMP loop\nend:\n"))
+    if err != nil || len(code) != 2 || len(strs) != 1 || strs[0] != "hi" || labels["loop"] != 0 || labels["end"] != 2 {
+        t.Errorf("FAIL: unexpected program %v %q %v: %v", code, strs, labels, err)
+    }
+    code, _, _, err = Assemble(strings.NewReader("LDI r1, 1\nLDI r1\nJUMP nowhere\n"))
+    var errorList token.ErrorList
+    if !errors.As(err, &errorList) || len(errorList) != 2 || code != nil {
+        t.Errorf("FAIL: expected 2 errors, got %v: %v", code, err)
+    }
+}
+
+// Tests that assembly stops at the first instruction beyond MaxCodeSize. 
+func TestMaxCodeSize(t *testing.T) {
+    assembler := NewAssembler()
+    assembler.MaxCodeSize = 2
+    lines := []string{"LDI r1, 1", "", "ADD r1, r1", "end: STDOUT r1", "JUMP nowhere", "JUMP end"}
+    code, err := assembler.AssembleLines(lines)
+    var errorList token.ErrorList
+    if !errors.As(err, &errorList) || len(errorList) != 1 || errorList[0].Line != 4 || !strings.Contains(err.Error(), "program too large") {
+        t.Errorf("FAIL: expected program too large at line 4, got: %v", err)
+    }
+    if len(code) != 2 || !assembler.Full() {
+        t.Errorf("FAIL: expected assembly to stop after 2 instructions, got %d", len(code))
+    }
+
+    assembler = NewAssembler()
+    assembler.MaxCodeSize = 3
+    if code, err := assembler.AssembleLines([]string{"LDI r1, 1", "loop: ADD r1, r1", "JUMP end", "end:"}); err != nil || len(code) != 3 {
+        t.Errorf("FAIL: expected 3 instructions, got %d: %v", len(code), err)
+    }
+}
@@ -5,6 +5,8 @@ package token
 
 import (
     "fmt"
+    "errors"
+    "strings"
 )
 
 // Defining token types as constants 
@@ -12,6 +14,8 @@ const (
     INT     = "INT"
     REG     = "REG"
     COMMA   = "COMMA"
+    LBRACKET = "LBRACKET"
+    RBRACKET = "RBRACKET"
     LDI     = "LDI"
     STDOUT  = "STDOUT"
     JUMP    = "JUMP"
@@ -21,19 +25,159 @@ const (
     BLINK   = "BLINK"
     SHAPE   = "SHAPE"
     PRINTR  = "PRINTR"
+    SUB     = "SUB"
+    MUL     = "MUL"
+    DIV     = "DIV"
+    MOD     = "MOD"
+    NEG     = "NEG"
+    CMP     = "CMP"
+    JEQ     = "JEQ"
+    JNE     = "JNE"
+    JLT     = "JLT"
+    JGT     = "JGT"
+    JLE     = "JLE"
+    JGE     = "JGE"
+    CALL    = "CALL"
+    RET     = "RET"
+    LD      = "LD"
+    ST      = "ST"
+    PUSH    = "PUSH"
+    POP     = "POP"
+    AND     = "AND"
+    OR      = "OR"
+    XOR     = "XOR"
+    NOT     = "NOT"
+    SHL     = "SHL"
+    SHR     = "SHR"
+    SAR     = "SAR"
+    MOV     = "MOV"
+    ADDI    = "ADDI"
+    SUBI    = "SUBI"
+    MULI    = "MULI"
+    DIVI    = "DIVI"
+    MODI    = "MODI"
+    ANDI    = "ANDI"
+    ORI     = "ORI"
+    XORI    = "XORI"
+    HALT    = "HALT"
+    NOP     = "NOP"
+    STDIN   = "STDIN"
+    GETC    = "GETC"
+    PUTC    = "PUTC"
+    PRINT   = "PRINT"
+    PRINTD  = "PRINTD"
+    PRINTX  = "PRINTX"
+    PRINTB  = "PRINTB"
+    STRING  = "STRING" // string literal, e.g. "hello\n"
+    LABEL   = "LABEL" // label definition, e.g. 'loop:'
+    IDENT   = "IDENT" // label reference, e.g. 'JUMP loop'
     EOF     = "EOF"
 )
 
-// Type int32 is used to be consistent with the source's ISA
+// Type int32 is used to be consistent with the source's ISA. Literal
+// holds the source text of tokens which are names rather than values 
+// (e.g., labels), or the text of a string literal with its escape 
+// sequences resolved. Line and Column give the 1-based source position of
+// the first character of the token. 
 type Token struct {
     TokenType string
     Value     int32
+    Literal   string
+    Line      int
+    Column    int
 }
 
 func New(tokenType string, value int32) *Token {
     return &Token{TokenType: tokenType, Value: value}
 }
 
+// NewLiteral creates a token whose meaning is carried by its source 
+// text rather than an int32 value. 
+func NewLiteral(tokenType string, literal string) *Token {
+    return &Token{TokenType: tokenType, Literal: literal}
+}
+
 func (t Token) String() string {
+    if t.Literal != "" {
+        return fmt.Sprintf("{Type: %s | Literal: %s}", t.TokenType, t.Literal)
+    }
     return fmt.Sprintf("{Type: %s | Value: %d}", t.TokenType, t.Value)
 }
+
+// SourceError is an error annotated with its location in the source 
+// program. Line and Column are 1-based. The lexer and parser only know
+// the line and column of an error; the file name and the text of the 
+// offending line (Source) are filled in by the 'vm' package. 
+type SourceError struct {
+    File   string
+    Line   int
+    Column int
+    Source string
+    Err    error
+}
+
+func NewError(line, column int, err error) *SourceError {
+    return &SourceError{Line: line, Column: column, Err: err}
+}
+
+// Error formats the error as 'gvm: file:line:column: message' followed
+// by the offending source line with a caret under the error column. 
+func (e *SourceError) Error() string {
+    var builder strings.Builder
+    builder.WriteString("gvm: ")
+    if e.File != "" {
+        builder.WriteString(e.File + ":")
+    }
+    fmt.Fprintf(&builder, "%d:%d: %s", e.Line, e.Column, strings.TrimPrefix(e.Err.Error(), "gvm: "))
+    if e.Source != "" {
+        builder.WriteString("\n    " + e.Source + "\n    ")
+        // keep tabs so the caret lines up with the source line
+        for i := 0; i < e.Column - 1 && i < len(e.Source); i++ {
+            if e.Source[i] == '\t' {
+                builder.WriteByte('\t')
+            } else {
+                builder.WriteByte(' ')
+            }
+        }
+        builder.WriteByte('^')
+    }
+    return builder.String()
+}
+
+func (e *SourceError) Unwrap() error {
+    return e.Err
+}
+
+// ErrorList is a list of SourceErrors. It is returned when more than one 
+// error may be reported at once (e.g., every syntax error in a program)
+// and may be iterated over to inspect each error. 
+type ErrorList []*SourceError
+
+// ErrTooManyErrors is wrapped by the last entry of an ErrorList which was
+// cut short, e.g., after the maximum number of syntax errors. The entry 
+// is placed at the position of the first error left out. 
+var ErrTooManyErrors = errors.New("gvm: too many errors")
+
+// Error formats each error in the list on its own line(s), followed by 
+// the number of errors when there is more than one and the list was not
+// cut short. 
+func (list ErrorList) Error() string {
+    messages := make([]string, len(list))
+    for i, err := range list {
+        messages[i] = err.Error()
+    }
+    if len(list) > 1 && !errors.Is(list[len(list) - 1].Err, ErrTooManyErrors) {
+        messages = append(messages, fmt.Sprintf("gvm: %d errors", len(list)))
+    }
+    return strings.Join(messages, "\n")
+}
+
+// Unwrap returns the errors in the list so that errors.Is and errors.As
+// can match any of them. 
+func (list ErrorList) Unwrap() []error {
+    errs := make([]error, len(list))
+    for i, err := range list {
+        errs[i] = err
+    }
+    return errs
+}
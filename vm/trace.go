@@ -0,0 +1,93 @@
+package vm
+
+import (
+    "fmt"
+    "strings"
+    "encoding/json"
+    "gvm/interpreter"
+)
+
+// Trace formats
+const (
+    TRACE_TEXT = "text" // one human-readable line per instruction
+    TRACE_JSON = "json" // one JSON object per instruction (JSON Lines)
+)
+
+// Change records a register whose value was changed by an instruction.
+// The stack pointer and the status flags are reported as the registers
+// SP and FLAGS.
+type Change struct {
+    Register string `json:"reg"`
+    Old int32 `json:"old"`
+    New int32 `json:"new"`
+}
+
+// TraceEntry describes a single executed instruction: its step number
+// (starting at 1), its address, its text and the registers it changed.
+type TraceEntry struct {
+    Step int64 `json:"step"`
+    PC int32 `json:"pc"`
+    Instruction string `json:"instr"`
+    Changes []Change `json:"changes"`
+}
+
+// String formats the entry as a line of the text trace, e.g.,
+//      3  addr 2: ADD r1, r2              R1: 4 -> 6
+func (e TraceEntry) String() string {
+    changes := make([]string, len(e.Changes))
+    for i, change := range e.Changes {
+        changes[i] = fmt.Sprintf("%s: %d -> %d",change.Register,change.Old,change.New)
+    }
+    line := fmt.Sprintf("%6d  addr %d: %-24s %s",e.Step,e.PC,e.Instruction,strings.Join(changes, ", "))
+    return strings.TrimRight(line, " ")
+}
+
+// SetTrace enables tracing of the program: every instruction executed by
+// Run or Step is written to the VM's Trace writer in the VM's TraceFormat.
+// Tracing is disabled when Trace is nil.
+func (vm *VirtualMachine) SetTrace() error {
+    if vm.Trace == nil {
+        vm.Interpreter.Trace = nil
+        return nil
+    }
+    var encoder *json.Encoder
+    switch vm.TraceFormat {
+    case TRACE_TEXT, "":
+    case TRACE_JSON:
+        encoder = json.NewEncoder(vm.Trace)
+    default:
+        return fmt.Errorf("gvm: unknown trace format '%s' (use %s or %s)",vm.TraceFormat,TRACE_TEXT,TRACE_JSON)
+    }
+    vm.Interpreter.Trace = func(before interpreter.State) {
+        entry := vm.TraceEntry(before, vm.Interpreter.State())
+        if encoder != nil {
+            encoder.Encode(entry)
+        } else {
+            fmt.Fprintln(vm.Trace, entry)
+        }
+    }
+    return nil
+}
+
+// TraceEntry compares the interpreter states before and after an
+// instruction to describe the instruction and the registers it changed.
+func (vm *VirtualMachine) TraceEntry(before, after interpreter.State) TraceEntry {
+    entry := TraceEntry{
+        Step: after.Steps,
+        PC: before.PC,
+        Instruction: vm.Instruction(before.PC),
+        Changes: []Change{},
+    }
+    for i := range before.Registers {
+        if before.Registers[i] != after.Registers[i] {
+            entry.Changes = append(entry.Changes, Change{fmt.Sprintf("R%d",i), before.Registers[i], after.Registers[i]})
+        }
+    }
+    if before.SP != after.SP {
+        entry.Changes = append(entry.Changes, Change{"SP", before.SP, after.SP})
+    }
+    if before.Flags != after.Flags {
+        entry.Changes = append(entry.Changes, Change{"FLAGS", before.Flags, after.Flags})
+    }
+    return entry
+}
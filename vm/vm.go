@@ -12,17 +12,36 @@ package vm
 import (
     "fmt"
     "os"
-    "bufio"
+    "io"
+    "strings"
+    "errors"
+    "gvm/token"
     "gvm/parser"
     "gvm/instructions"
     "gvm/interpreter"
+    "gvm/disasm"
+    "gvm/bytecode"
 )
 
 const ( 
     NUM_REGISTERS = 10 // constant. Does not change
     INIT  = 10 // initial codeblock size
+    MAX_CODE_SIZE = 4096 // default hard ceiling on the codeblock size
+    CALL_STACK_SIZE = 256 // default maximum depth of nested CALLs
+    DATA_SIZE = 1024 // default data memory size in 32-bit words
+    STACK_SIZE = 256 // default size of the data stack in 32-bit words
 )
 
+// ExitError is returned by Execute when a program stops with a HALT 
+// instruction giving a non-zero exit status. 
+type ExitError struct {
+    Status int32
+}
+
+func (e *ExitError) Error() string {
+    return fmt.Sprintf("gvm: exit status %d",e.Status)
+}
+
 // VirtualMemory defines the memory architecture of the virtual machine. It
 // contains the registers, the executatble code block, and the size of the
 // code block so that the last address in the code block is immediately
@@ -46,21 +65,130 @@ type VirtualMemory struct {
     // code block to prevent writing to or branching to restricted 
     // or invalid memory addresses. 
     CodeSize int
+
+    // MaxCodeSize is the hard ceiling on the number of instructions the
+    // code block may hold. The code block grows on demand up to this 
+    // limit; loading a program with more instructions than this results
+    // in a "program too large" error. 
+    MaxCodeSize int
+
+    // Labels maps the label names defined in the program to their code 
+    // block addresses. Label names are preserved after assembly so they
+    // are available for diagnostics. 
+    Labels map[string]int32
+
+    // SourceMap holds the source token at which each instruction in the 
+    // code block begins, so runtime errors can be traced back to the 
+    // line and column of the faulting instruction. 
+    SourceMap []token.Token
+
+    // Strings is the string table of the program: the text printed by 
+    // each PRINT instruction, which refers to its string by index. 
+    Strings []string
+
+    // Symbols names the labelled addresses and jump targets of the program
+    // for the disassembler (see the 'disasm' package). 
+    Symbols map[int32]string
+
+    // CallStack holds the return addresses of the subroutine CALLs which
+    // have not yet returned. It is managed by the virtual machine and is 
+    // not addressable by Susan programs. MaxCallDepth is the number of 
+    // return addresses it can hold; a CALL beyond this depth results in 
+    // a stack overflow error. 
+    CallStack []int32
+    MaxCallDepth int
+
+    // Data is the word-addressable data memory read and written by the LD
+    // and ST instructions. Each address holds one int32 word, and 
+    // addresses run from 0 to DataSize - 1. Accessing any other address 
+    // results in a segmentation violation error. 
+    Data []int32
+    DataSize int
+
+    // StackSize is the number of words at the top of the data memory 
+    // reserved for the data stack used by PUSH and POP. The stack grows
+    // downwards from address DataSize - 1; pushing more than StackSize 
+    // words results in a stack overflow error. 
+    StackSize int
 }
 
 // NewVirtualMemory initializes a new VirtualMemory instance to be used to 
 // represent Susan's memory image within the virtual machine. It initializes
-// the registers, the code block Code with an initial size of INIT_CB_SIZE,
+// the registers, the code block Code with an initial size of INIT,
 // and initializes CodeSize as 0 indicating that no instructions have been
-// written yet. The virtual memory provides an isolated environment for 
-// the virtual machine to load and execute programs with.
+// written yet. The code block ceiling defaults to MAX_CODE_SIZE and 
+// the call stack depth to CALL_STACK_SIZE, the data memory size to 
+// DATA_SIZE words and the data stack size to STACK_SIZE words. The call
+// stack and data memory themselves are allocated when the program is 
+// executed. The virtual memory provides an isolated environment for the
+// virtual machine to load and execute programs with.
 
 func NewVirtualMemory() *VirtualMemory {
     return &VirtualMemory{
         Registers: make([]int32, NUM_REGISTERS),
         Code: make([]instructions.Instruction, INIT), 
         CodeSize: 0,
+        MaxCodeSize: MAX_CODE_SIZE,
+        MaxCallDepth: CALL_STACK_SIZE,
+        DataSize: DATA_SIZE,
+        StackSize: STACK_SIZE,
+    }
+}
+
+// SetCode replaces the program in the code block with code, growing the
+// code block as WriteCode does. If code does not fit within MaxCodeSize 
+// instructions, a program too large error is returned and the code block
+// is left unchanged. 
+func (vMem *VirtualMemory) SetCode(code []instructions.Instruction) error {
+    if len(code) > vMem.MaxCodeSize {
+        return fmt.Errorf("gvm: program too large: exceeds %d instructions",vMem.MaxCodeSize)
+    }
+    if len(code) > len(vMem.Code) {
+        newSize := len(vMem.Code)
+        if newSize == 0 {
+            newSize = INIT
+        }
+        for newSize < len(code) {
+            newSize *= 2
+        }
+        if newSize > vMem.MaxCodeSize {
+            newSize = vMem.MaxCodeSize
+        }
+        vMem.Code = make([]instructions.Instruction, newSize)
+    }
+    copy(vMem.Code, code)
+    // drop the rest of the previous program
+    for addr := len(code); addr < vMem.CodeSize; addr++ {
+        vMem.Code[addr] = nil
+    }
+    vMem.CodeSize = len(code)
+    return nil
+}
+
+// WriteCode writes a bytecode instruction into the next free address of 
+// the code block. If the code block is full, its size is doubled (up to 
+// MaxCodeSize) before the instruction is written. If the program does 
+// not fit within MaxCodeSize instructions, a program too large error 
+// is returned. 
+func (vMem *VirtualMemory) WriteCode(instr instructions.Instruction) error {
+    if vMem.CodeSize >= vMem.MaxCodeSize {
+        return fmt.Errorf("gvm: program too large: exceeds %d instructions",vMem.MaxCodeSize)
+    }
+    if vMem.CodeSize >= len(vMem.Code) {
+        newSize := 2 * len(vMem.Code)
+        if newSize == 0 {
+            newSize = INIT
+        }
+        if newSize > vMem.MaxCodeSize {
+            newSize = vMem.MaxCodeSize
+        }
+        code := make([]instructions.Instruction, newSize)
+        copy(code, vMem.Code[:vMem.CodeSize])
+        vMem.Code = code
     }
+    vMem.Code[vMem.CodeSize] = instr
+    vMem.CodeSize += 1
+    return nil
 }
 
 
@@ -87,6 +215,25 @@ type VirtualMachine struct {
     // the instructions by dispatching to the routine indicated by 
     // each instructions opcode. 
     Interpreter *interpreter.Interpreter
+
+    // File is the name of the loaded program and Source holds its lines.
+    // They are attached to errors to show where in the program an error
+    // occured. 
+    File string
+    Source []string
+
+    // fileName is the name taken from the last program read from a file,
+    // so that it is not kept for a program read from another reader. 
+    fileName string
+
+    // MaxErrors is the number of syntax errors reported before assembly
+    // of a program is abandoned. A value <= 0 reports every error. 
+    MaxErrors int
+
+    // Trace, if not nil, receives a trace of every instruction executed,
+    // written in TraceFormat (TRACE_TEXT by default, or TRACE_JSON). 
+    Trace io.Writer
+    TraceFormat string
 }
 
 // NewVirtualMachine initializes a new VirtualMachine instance. It 
@@ -98,40 +245,116 @@ type VirtualMachine struct {
 // with control is returned back to the VM 
 // 
 // Initialize a virtual machine with pre-allocated virtual memory and 
-// an interpreter with a reference to the virtual memory. 
-func NewVirtualMachine() *VirtualMachine {
+// an interpreter with a reference to the virtual memory. Everything the
+// program prints is written to output, or to os.Stdout if output is nil.
+func NewVirtualMachine(output io.Writer) *VirtualMachine {
     vMem := NewVirtualMemory()
     return &VirtualMachine{
         VMem: vMem,
-        Interpreter: interpreter.New(vMem.Registers, vMem.Code),
+        Interpreter: interpreter.New(vMem.Registers, vMem.Code, output),
+        MaxErrors: parser.MAX_ERRORS,
     }
 }
 
-// ParseInstructions reads each line in the source code file, 
-// where each line is an instruction within Susan's instruction 
-// set. Each instruction is tokenized, parsed into represent-
-// ative bytecode instructions, and written into the virtual
-// memory code block section. 
+// ParseInstructions reads each line in the source code, where each 
+// line is an instruction within Susan's instruction set. The program is
+// assembled in two passes: the first pass records the address of each 
+// label, and the second pass tokenizes and parses each instruction into
+// representative bytecode instructions which are written into the 
+// virtual memory code block section, replacing any program loaded 
+// before. If any syntax errors are found, the first MaxErrors of them 
+// (by source position) are returned together as a token.ErrorList, 
+// followed by a token.ErrTooManyErrors entry if there were more. 
+//
+// Assembly stops at the first instruction beyond the code block ceiling
+// (MaxCodeSize) with a program too large error. The virtual memory is 
+// only changed once the whole program has been assembled, so a program 
+// which fails to assemble leaves the program loaded before in place. 
+//
+// If sourceCode is a file, its name is used as the program's File. 
+func (vm *VirtualMachine) ParseInstructions(sourceCode io.Reader) error {
+    vm.setFile(sourceCode)
+
+    // assembly stops at the code block ceiling, and the virtual memory is
+    // only changed once the whole program has been assembled 
+    assembler := parser.NewAssembler()
+    assembler.MaxErrors = vm.MaxErrors
+    assembler.MaxCodeSize = vm.VMem.MaxCodeSize
+    source, code, err := assembler.AssembleReader(sourceCode)
+    vm.Source = source
+    if err != nil {
+        return vm.Locate(err)
+    }
+    if err := vm.VMem.SetCode(code); err != nil {
+        return err
+    }
+    vm.VMem.Labels = assembler.Labels
+    vm.VMem.SourceMap = assembler.SourceMap
+    vm.VMem.Strings = assembler.Strings
+    vm.VMem.Symbols = disasm.Symbols(vm.VMem.Code[:vm.VMem.CodeSize], vm.VMem.Labels)
+    vm.Interpreter.Strings = vm.VMem.Strings
 
+    // the code block may have been reallocated while growing, so the 
+    // interpreter is given the current code block 
+    vm.Interpreter.Code = vm.VMem.Code
+    return nil
+}
 
-func (vm *VirtualMachine) ParseInstructions(sourceCode *os.File) error {
-    scanner := bufio.NewScanner(sourceCode)
-    for scanner.Scan() {
-        sourceInstruction := scanner.Text()
-        parser, err := parser.New(sourceInstruction)
-        if err != nil {
-            return err
+// setFile sets the program's File to the name of r if r is a file. A 
+// name taken from an earlier file is cleared for a program read from 
+// any other reader, while a File set by the caller is kept. 
+func (vm *VirtualMachine) setFile(r io.Reader) {
+    if file, ok := r.(interface{ Name() string }); ok {
+        vm.File = file.Name()
+        vm.fileName = vm.File
+        return
+    }
+    if vm.File == vm.fileName {
+        vm.File = ""
+    }
+    vm.fileName = ""
+}
+
+// Locate completes a SourceError (or each error in an ErrorList) returned
+// from the lexer or parser with the program file name and the text of the
+// offending line. Errors without a source position are returned unchanged.
+func (vm *VirtualMachine) Locate(err error) error {
+    var errorList token.ErrorList
+    if errors.As(err, &errorList) {
+        for _, sourceErr := range errorList {
+            vm.locate(sourceErr)
         }
-        // get bytecode instruction from source instruction
-        byteCodeInstr, err := parser.Instruction() 
-        if err != nil {
-            return err
-        } 
-        // write bytecode instruction to virtual memory 
-        vm.VMem.Code[vm.VMem.CodeSize] = byteCodeInstr
-        vm.VMem.CodeSize += 1
+        return err
     }
-    return nil
+    var sourceErr *token.SourceError
+    if errors.As(err, &sourceErr) {
+        vm.locate(sourceErr)
+    }
+    return err
+}
+
+func (vm *VirtualMachine) locate(sourceErr *token.SourceError) {
+    sourceErr.File = vm.File
+    if sourceErr.Line > 0 && sourceErr.Line <= len(vm.Source) {
+        sourceErr.Source = vm.Source[sourceErr.Line - 1]
+    }
+}
+
+// RuntimeError attaches the source position of the instruction at the 
+// interpreter's current PC to an error returned by the interpreter. A 
+// program without source code (e.g., loaded from an object file) has no
+// source position, so the address and disassembly of the instruction 
+// are attached instead. 
+func (vm *VirtualMachine) RuntimeError(err error) error {
+    pc := int(vm.Interpreter.PC)
+    if pc < 0 || pc >= vm.VMem.CodeSize {
+        return err
+    }
+    if pc >= len(vm.VMem.SourceMap) {
+        return fmt.Errorf("%w\n    at addr %d: %s",err,pc,vm.Instruction(int32(pc)))
+    }
+    instrToken := vm.VMem.SourceMap[pc]
+    return vm.Locate(token.NewError(instrToken.Line, instrToken.Column, err))
 }
 
 // Execute is the control transfer center of the virtual 
@@ -148,9 +371,10 @@ func (vm *VirtualMachine) ParseInstructions(sourceCode *os.File) error {
 // for printing to the screen. 
 //
 // Any errors which occur are propagated from the source 
-// and returned and handled here.
-
-
+// and returned and handled here. Errors are annotated with
+// the file, line and column where they occured. If the 
+// program stops with HALT Rr and a non-zero exit status, 
+// an ExitError holding the status is returned. 
 func (vm *VirtualMachine) Execute(file string) error {    
 
     // Load program code
@@ -160,6 +384,32 @@ func (vm *VirtualMachine) Execute(file string) error {
     }
     defer sourceCode.Close()
 
+    return vm.ExecuteSource(sourceCode)
+}
+
+// ExecuteSource loads the Susan program read from sourceCode and runs it,
+// as Execute does for a file. Errors are annotated with the VM's File 
+// name, which may be set beforehand to name a program which is not read 
+// from a file. 
+func (vm *VirtualMachine) ExecuteSource(sourceCode io.Reader) error {
+    if err := vm.Load(sourceCode); err != nil {
+        return err
+    }
+    return vm.Run()
+}
+
+// ExecuteString loads the Susan program held in source and runs it. 
+func (vm *VirtualMachine) ExecuteString(source string) error {
+    return vm.ExecuteSource(strings.NewReader(source))
+}
+
+// Load assembles the Susan program read from sourceCode into the virtual
+// memory code block and writes the last address of the code block into 
+// register 0, leaving the program ready to be started by Run. Syntax 
+// errors are returned as they are by Execute. 
+func (vm *VirtualMachine) Load(sourceCode io.Reader) error {
+    vm.VMem.Registers[0] = 0 // no program is loaded if assembly fails
+
     // Parse source instructions as bytecode into the virtual
     // memory code block 
     if err := vm.ParseInstructions(sourceCode); err != nil {
@@ -167,10 +417,124 @@ func (vm *VirtualMachine) Execute(file string) error {
     }
     // Write last address of code block to register 0
     vm.VMem.Registers[0] = int32(vm.VMem.CodeSize)
+    return nil
+}
+
+// LoadObject loads the program held in the object file read from r (see
+// the 'bytecode' package) into the virtual memory code block, as Load does
+// for a program's source code. Programs loaded from object files have no
+// source, so runtime errors are reported without a source position. 
+func (vm *VirtualMachine) LoadObject(r io.Reader) error {
+    vm.VMem.Registers[0] = 0 // no program is loaded if loading fails
+    vm.setFile(r)
+    obj, err := bytecode.Read(r)
+    if err != nil {
+        return err
+    }
+    if err := vm.VMem.SetCode(obj.Code); err != nil {
+        return err
+    }
+    vm.Source = nil
+    vm.VMem.Labels = obj.Labels
+    vm.VMem.SourceMap = nil
+    vm.VMem.Strings = obj.Strings
+    vm.VMem.Symbols = disasm.Symbols(vm.VMem.Code[:vm.VMem.CodeSize], vm.VMem.Labels)
+    vm.Interpreter.Strings = vm.VMem.Strings
+    vm.Interpreter.Code = vm.VMem.Code
+    vm.VMem.Registers[0] = int32(vm.VMem.CodeSize)
+    return nil
+}
+
+// WriteObject writes the loaded program to w as an object file which can
+// be loaded by LoadObject. 
+func (vm *VirtualMachine) WriteObject(w io.Writer) error {
+    return bytecode.Write(w, &bytecode.Object{
+        Code: vm.VMem.Code[:vm.VMem.CodeSize],
+        Strings: vm.VMem.Strings,
+        Labels: vm.VMem.Labels,
+    })
+}
+
+// ExecuteObject loads the program held in the object file read from r and
+// runs it. 
+func (vm *VirtualMachine) ExecuteObject(r io.Reader) error {
+    if err := vm.LoadObject(r); err != nil {
+        return err
+    }
+    return vm.Run()
+}
+
+// Run executes the program loaded by Load from its initial state, so a
+// loaded program may be run more than once. Runtime errors and a non-zero
+// exit status are returned as they are by Execute. 
+func (vm *VirtualMachine) Run() error {
+    if err := vm.Reset(); err != nil {
+        return err
+    }
 
     // Invoke interpreter to execute program
     if err := vm.Interpreter.Interpret(); err != nil {
-        return err
+        return vm.RuntimeError(err)
+    }
+    return vm.Exit()
+}
+
+// Reset prepares the loaded program to be executed from the start, 
+// without re-parsing it. The call stack and data memory are allocated,
+// the interpreter is reset to its initial state and tracing is set up.
+func (vm *VirtualMachine) Reset() error {
+
+    // The data stack starts empty at the top of the data memory 
+    if vm.VMem.StackSize > vm.VMem.DataSize {
+        return fmt.Errorf("gvm: stack size (%d words) exceeds data memory size (%d words)",vm.VMem.StackSize,vm.VMem.DataSize)
+    }
+
+    // Allocate the call stack and data memory and give the interpreter a 
+    // reference to them
+    vm.VMem.CallStack = make([]int32, vm.VMem.MaxCallDepth)
+    vm.Interpreter.CallStack = vm.VMem.CallStack
+    vm.VMem.Data = make([]int32, vm.VMem.DataSize)
+    vm.Interpreter.Data = vm.VMem.Data
+    vm.Interpreter.StackLimit = int32(vm.VMem.DataSize - vm.VMem.StackSize)
+
+    vm.Interpreter.Reset()
+    return vm.SetTrace()
+}
+
+// Step executes a single instruction of the program prepared by Reset 
+// and reports whether the program has ended. Runtime errors are located
+// as they are by Run. 
+func (vm *VirtualMachine) Step() (bool, error) {
+    halted, err := vm.Interpreter.Step()
+    if err != nil {
+        return false, vm.RuntimeError(err)
+    }
+    return halted, nil
+}
+
+// Disassemble writes the loaded program to w as Susan source code, with 
+// its labels and the address of each instruction. 
+func (vm *VirtualMachine) Disassemble(w io.Writer) error {
+    return disasm.Disassemble(w, vm.VMem.Code[:vm.VMem.CodeSize], vm.VMem.Strings, vm.VMem.Labels)
+}
+
+// Instruction disassembles the instruction at addr, e.g., 'CALL double'.
+func (vm *VirtualMachine) Instruction(addr int32) string {
+    if addr < 0 || int(addr) >= vm.VMem.CodeSize {
+        return ""
+    }
+    text, err := disasm.Instruction(vm.VMem.Code[addr], vm.VMem.Strings, vm.VMem.Symbols)
+    if err != nil {
+        return vm.VMem.Code[addr].String()
+    }
+    return text
+}
+
+// Exit returns an ExitError if the program stopped with HALT Rr and a 
+// non-zero exit status, and nil otherwise. 
+func (vm *VirtualMachine) Exit() error {
+    if vm.Interpreter.ExitStatus != 0 {
+        return &ExitError{Status: vm.Interpreter.ExitStatus}
     }
     return nil
 }
@@ -4,7 +4,14 @@
 package vm
 
 import (
+    "fmt"
+    "errors"
+    "io"
+    "strings"
     "testing"
+    "time"
+    "gvm/token"
+    "gvm/interpreter"
 )
 
 type TestCase struct {
@@ -27,11 +34,44 @@ func TestParser(t *testing.T) {
     {"testdata/test10", true},
     {"testdata/test11", false},
     {"testdata/test12", false},
+    {"testdata/test13", true},
+    {"testdata/test14", true},
+    {"testdata/test15", false},
+    {"testdata/test16", false},
+    {"testdata/test17", true},
+    {"testdata/test18", false},
+    {"testdata/test19", true},
+    {"testdata/test20", false},
+    {"testdata/test21", false},
+    {"testdata/test22", true},
+    {"testdata/test23", false},
+    {"testdata/test24", true},
+    {"testdata/test25", true},
+    {"testdata/test26", true},
+    {"testdata/test27", true},
+    {"testdata/test28", false},
+    {"testdata/test29", false},
+    {"testdata/test30", true},
+    {"testdata/test31", true},
+    {"testdata/test32", false},
+    {"testdata/test33", false},
+    {"testdata/test34", true},
+    {"testdata/test35", false},
+    {"testdata/test36", false},
+    {"testdata/test37", true},
+    {"testdata/test38", true},
+    {"testdata/test39", false},
+    {"testdata/test40", false},
+    {"testdata/test41", true},
+    {"testdata/test42", false},
+    {"testdata/test45", true},
+    {"testdata/test46", true},
+    {"testdata/test47", true},
     }
 
     for _, testCase := range testCases {
 
-        vm := NewVirtualMachine()
+        vm := NewVirtualMachine(io.Discard)
         err := vm.Execute(testCase.input)
 
         if err == nil && !testCase.shouldPass {
@@ -46,3 +86,539 @@ func TestParser(t *testing.T) {
         }
     }
 }
+
+// Tests that a program larger than the code block ceiling is rejected 
+// with an error rather than overrunning the code block, and that the 
+// program loaded before is left unchanged. 
+func TestMaxCodeSize(t *testing.T) {
+    vm := NewVirtualMachine(io.Discard)
+    vm.VMem.MaxCodeSize = 11
+    if err := vm.ExecuteString("start: LDI r1, 1\nPRINT \"hi\"\n"); err != nil {
+        t.Fatalf("Error returned from valid program: %v", err)
+    }
+    err := vm.Execute("testdata/test13")
+    var sourceErr *token.SourceError
+    if !errors.As(err, &sourceErr) || sourceErr.Line != 12 || !strings.Contains(err.Error(), "program too large: exceeds 11") {
+        t.Errorf("Expected program too large at line 12, got: %v", err)
+    }
+    if vm.VMem.CodeSize != 2 || len(vm.VMem.SourceMap) != 2 || vm.VMem.Labels["start"] != 0 || len(vm.VMem.Strings) != 1 {
+        t.Errorf("Expected the previous program to be left unchanged, got %d instructions, labels %v", vm.VMem.CodeSize, vm.VMem.Labels)
+    }
+    if vm.VMem.Registers[0] != 0 {
+        t.Errorf("Expected no program to be loaded, got R0 = %d", vm.VMem.Registers[0])
+    }
+
+    vm.VMem.MaxCodeSize = 12
+    if err := vm.Execute("testdata/test13"); err != nil || vm.VMem.CodeSize != 12 {
+        t.Errorf("Expected a program of 12 instructions, got %d: %v", vm.VMem.CodeSize, err)
+    }
+}
+
+// Tests that syntax and runtime errors report the line and column of the
+// offending token. 
+func TestErrorPositions(t *testing.T) {
+    testCases := []struct {
+        input string
+        line, column int
+    }{
+        {"testdata/test1", 2, 8},   // parser 
+        {"testdata/test2", 1, 4},   // lexer 
+        {"testdata/test8", 2, 1},   // interpreter 
+        {"testdata/test15", 3, 6},  // undefined label 
+        {"testdata/test16", 4, 1},  // duplicate label 
+    }
+
+    for _, testCase := range testCases {
+        vm := NewVirtualMachine(io.Discard)
+        err := vm.Execute(testCase.input)
+
+        var sourceErr *token.SourceError
+        if !errors.As(err, &sourceErr) {
+            t.Errorf("%s: expected a SourceError, got: %v", testCase.input, err)
+            continue
+        }
+        if sourceErr.File != testCase.input || sourceErr.Line != testCase.line || sourceErr.Column != testCase.column {
+            t.Errorf("%s: expected %d:%d, got %s:%d:%d", testCase.input, testCase.line, testCase.column,
+                sourceErr.File, sourceErr.Line, sourceErr.Column)
+        }
+        if sourceErr.Source == "" {
+            t.Errorf("%s: source line missing from error", testCase.input)
+        }
+    }
+}
+
+// Tests that every syntax error in a file is reported, in line order, up
+// to the configured maximum number of errors, and that a list which was
+// cut short ends with a 'too many errors' entry. 
+func TestErrorList(t *testing.T) {
+    testCases := []struct {
+        maxErrors int
+        lines []int
+        tooMany bool
+    }{
+        {0, []int{1, 3, 5, 6}, false},
+        {10, []int{1, 3, 5, 6}, false},
+        {4, []int{1, 3, 5, 6}, false},
+        {3, []int{1, 3, 5, 6}, true},
+        {2, []int{1, 3, 5}, true}, // duplicate label found by the first pass 
+        {1, []int{1, 3}, true},
+    }
+
+    for _, testCase := range testCases {
+        vm := NewVirtualMachine(io.Discard)
+        vm.MaxErrors = testCase.maxErrors
+        err := vm.Execute("testdata/test18")
+
+        var errorList token.ErrorList
+        if !errors.As(err, &errorList) {
+            t.Errorf("MaxErrors %d: expected an ErrorList, got: %v", testCase.maxErrors, err)
+            continue
+        }
+        if len(errorList) != len(testCase.lines) {
+            t.Errorf("MaxErrors %d: expected %d errors, got %d: %v", testCase.maxErrors, len(testCase.lines), len(errorList), err)
+            continue
+        }
+        for i, sourceErr := range errorList {
+            if sourceErr.Line != testCase.lines[i] || sourceErr.File == "" {
+                t.Errorf("MaxErrors %d: error %d: expected line %d, got: %v", testCase.maxErrors, i, testCase.lines[i], sourceErr)
+            }
+        }
+        last := errorList[len(errorList) - 1]
+        if errors.Is(last, token.ErrTooManyErrors) != testCase.tooMany {
+            t.Errorf("MaxErrors %d: expected too many errors %v, got: %v", testCase.maxErrors, testCase.tooMany, err)
+        }
+        if testCase.tooMany && !strings.Contains(err.Error(), fmt.Sprintf("stopping after %d", testCase.maxErrors)) {
+            t.Errorf("MaxErrors %d: expected 'stopping after %d', got: %v", testCase.maxErrors, testCase.maxErrors, err)
+        }
+    }
+}
+
+// Tests that programs which never terminate are stopped by the instruction
+// budget or the timeout, and that terminating loops are not. 
+func TestExecutionLimit(t *testing.T) {
+    testCases := []struct {
+        input string
+        maxSteps int64
+        timeout time.Duration
+        shouldPass bool
+    }{
+        {"testdata/test25", 16, 0, true},  // exactly enough steps 
+        {"testdata/test25", 15, 0, false},
+        {"testdata/test11", 1000, 0, false},
+        {"testdata/test11", 0, 10 * time.Millisecond, false},
+    }
+
+    for _, testCase := range testCases {
+        vm := NewVirtualMachine(io.Discard)
+        vm.Interpreter.MaxSteps = testCase.maxSteps
+        vm.Interpreter.Timeout = testCase.timeout
+        err := vm.Execute(testCase.input)
+
+        if err != nil && testCase.shouldPass {
+            t.Errorf("Error returned from valid input file: %s: error message: %v", testCase.input, err)
+        }
+        if testCase.shouldPass {
+            continue
+        }
+        var limitErr *interpreter.LimitError
+        if !errors.As(err, &limitErr) {
+            t.Errorf("%s: expected a LimitError, got: %v", testCase.input, err)
+            continue
+        }
+        if testCase.maxSteps > 0 && limitErr.Steps != testCase.maxSteps {
+            t.Errorf("%s: expected %d steps, got %d", testCase.input, testCase.maxSteps, limitErr.Steps)
+        }
+    }
+}
+
+// Tests that the configured call stack depth bounds nested CALLs. 
+func TestCallDepth(t *testing.T) {
+    testCases := []struct {
+        maxCallDepth int
+        shouldPass bool
+    }{
+        {3, true},
+        {2, false},
+        {0, false},
+    }
+
+    for _, testCase := range testCases {
+        vm := NewVirtualMachine(io.Discard)
+        vm.VMem.MaxCallDepth = testCase.maxCallDepth
+        err := vm.Execute("testdata/test30")
+
+        if err == nil && !testCase.shouldPass {
+            t.Errorf("MaxCallDepth %d: no error returned from call depth 3", testCase.maxCallDepth)
+        }
+        if err != nil && testCase.shouldPass {
+            t.Errorf("MaxCallDepth %d: error returned: %v", testCase.maxCallDepth, err)
+        }
+    }
+}
+
+// Tests that the configured data memory size bounds LD and ST addresses. 
+func TestDataSize(t *testing.T) {
+    testCases := []struct {
+        input string
+        dataSize int
+        shouldPass bool
+    }{
+        {"testdata/test31", 5, true},
+        {"testdata/test31", 4, false},
+        {"testdata/test32", 1025, true},
+    }
+
+    for _, testCase := range testCases {
+        vm := NewVirtualMachine(io.Discard)
+        vm.VMem.DataSize = testCase.dataSize
+        vm.VMem.StackSize = 0
+        err := vm.Execute(testCase.input)
+
+        if err == nil && !testCase.shouldPass {
+            t.Errorf("%s: DataSize %d: no error returned", testCase.input, testCase.dataSize)
+        }
+        if err != nil && testCase.shouldPass {
+            t.Errorf("%s: DataSize %d: error returned: %v", testCase.input, testCase.dataSize, err)
+        }
+    }
+}
+
+// Tests that the configured stack size bounds PUSH, and that the stack 
+// must fit within the data memory. 
+func TestStackSize(t *testing.T) {
+    testCases := []struct {
+        input string
+        dataSize, stackSize int
+        shouldPass bool
+    }{
+        {"testdata/test34", 2, 2, true},
+        {"testdata/test34", 1024, 1, false},
+        {"testdata/test34", 4, 8, false},
+    }
+
+    for _, testCase := range testCases {
+        vm := NewVirtualMachine(io.Discard)
+        vm.VMem.DataSize = testCase.dataSize
+        vm.VMem.StackSize = testCase.stackSize
+        err := vm.Execute(testCase.input)
+
+        if err == nil && !testCase.shouldPass {
+            t.Errorf("%s: StackSize %d: no error returned", testCase.input, testCase.stackSize)
+        }
+        if err != nil && testCase.shouldPass {
+            t.Errorf("%s: StackSize %d: error returned: %v", testCase.input, testCase.stackSize, err)
+        }
+    }
+}
+
+// Tests that the exit status given to HALT is propagated out of Execute. 
+func TestExitStatus(t *testing.T) {
+    vm := NewVirtualMachine(io.Discard)
+    if err := vm.Execute("testdata/test41"); err != nil {
+        t.Errorf("testdata/test41: error returned from HALT: %v", err)
+    }
+    if !vm.Interpreter.Halted || vm.Interpreter.PC != 3 {
+        t.Errorf("testdata/test41: expected HALT at addr 3, got PC %d", vm.Interpreter.PC)
+    }
+
+    vm = NewVirtualMachine(io.Discard)
+    err := vm.Execute("testdata/test42")
+    var exitErr *ExitError
+    if !errors.As(err, &exitErr) || exitErr.Status != 3 {
+        t.Errorf("testdata/test42: expected exit status 3, got: %v", err)
+    }
+}
+
+// Tests that STDIN and GETC read from the interpreter's Input, that EOF 
+// is reported as EOF_VALUE and that malformed input is an error. 
+func TestInput(t *testing.T) {
+    testCases := []struct {
+        input, stdin string
+        result int32
+        shouldPass bool
+    }{
+        {"testdata/test43", "1 2 3\n", 6, true},
+        {"testdata/test43", "  -4\n\t0x10 0b11", 15, true},
+        {"testdata/test43", "", 0, true},
+        {"testdata/test43", "1 two 3", 0, false},
+        {"testdata/test43", "2147483648", 0, false},
+        {"testdata/test44", "hi", 'i', true},
+        {"testdata/test44", "", 0, true},
+    }
+
+    for _, testCase := range testCases {
+        vm := NewVirtualMachine(io.Discard)
+        vm.Interpreter.Input = strings.NewReader(testCase.stdin)
+        err := vm.Execute(testCase.input)
+
+        var exitErr *ExitError
+        if errors.As(err, &exitErr) {
+            err = nil
+        }
+        if err == nil && !testCase.shouldPass {
+            t.Errorf("%s: input %q: no error returned", testCase.input, testCase.stdin)
+        }
+        if err != nil && testCase.shouldPass {
+            t.Errorf("%s: input %q: error returned: %v", testCase.input, testCase.stdin, err)
+        }
+        if err == nil && vm.VMem.Registers[2] != testCase.result {
+            t.Errorf("%s: input %q: expected %d, got %d", testCase.input, testCase.stdin, testCase.result, vm.VMem.Registers[2])
+        }
+    }
+}
+
+// Tests that program output is written to the writer given to 
+// NewVirtualMachine. 
+func TestOutput(t *testing.T) {
+    testCases := []struct {
+        input, output string
+    }{
+        {"testdata/test0", "9\n"},
+        {"testdata/test19", "120\n17\n1\n6\n"},
+        {"testdata/test37", "8\n14\n6\n-7\n-2147483648\n-134217728\n15\n-1\n0\n0\n-1\n"},
+        {"testdata/test45", "Hi!\ndec: 255, hex: 0xff, bin: 0b11111111\n0xffffffff\n"},
+        {"testdata/test46", "321"},
+        {"testdata/test47", "call\n"},
+    }
+
+    for _, testCase := range testCases {
+        var output strings.Builder
+        vm := NewVirtualMachine(&output)
+        if err := vm.Execute(testCase.input); err != nil {
+            t.Errorf("%s: error returned: %v", testCase.input, err)
+            continue
+        }
+        if output.String() != testCase.output {
+            t.Errorf("%s: expected output %q, got %q", testCase.input, testCase.output, output.String())
+        }
+    }
+}
+
+// Tests that programs can be executed from a string or an io.Reader, and
+// that syntax errors in them are located without a file name. 
+func TestExecuteString(t *testing.T) {
+    var output strings.Builder
+    vm := NewVirtualMachine(&output)
+    vm.Interpreter.MaxSteps = 9
+    err := vm.ExecuteString("LDI r1, 2\nloop: PRINTD r1\nSUBI r1, 1\nJUMP loop ; never ends\n")
+    var limitErr *interpreter.LimitError
+    if !errors.As(err, &limitErr) || output.String() != "210" {
+        t.Errorf("Expected output %q and a LimitError, got %q: %v", "210", output.String(), err)
+    }
+
+    output.Reset()
+    vm = NewVirtualMachine(&output)
+    if err := vm.ExecuteSource(strings.NewReader("LDI r1, 7\nSTDOUT r1")); err != nil {
+        t.Errorf("Error returned from valid program: %v", err)
+    }
+    if output.String() != "7\n" {
+        t.Errorf("Expected output %q, got %q", "7\n", output.String())
+    }
+
+    vm = NewVirtualMachine(io.Discard)
+    err = vm.ExecuteString("LDI r1, 1\n\nADDr1, r1\n")
+    var sourceErr *token.SourceError
+    if !errors.As(err, &sourceErr) || sourceErr.File != "" || sourceErr.Line != 3 {
+        t.Errorf("Expected a SourceError at line 3, got: %v", err)
+    }
+
+    vm = NewVirtualMachine(io.Discard)
+    vm.File = "generated"
+    err = vm.ExecuteString("LDI r1, 0\nDIV r1, r1\n")
+    if !errors.As(err, &sourceErr) || sourceErr.File != "generated" || sourceErr.Line != 2 {
+        t.Errorf("Expected a SourceError at generated:2, got: %v", err)
+    }
+
+    // the name of a file run before is not kept for a string
+    vm = NewVirtualMachine(io.Discard)
+    if err := vm.Execute("testdata/test0"); err != nil {
+        t.Fatalf("Error returned from valid program: %v", err)
+    }
+    err = vm.ExecuteString("LDI r1, 0\nDIV r1, r1\n")
+    if !errors.As(err, &sourceErr) || sourceErr.File != "" || sourceErr.Line != 2 || strings.Contains(err.Error(), "test0") {
+        t.Errorf("Expected a SourceError at line 2 without a file name, got: %v", err)
+    }
+}
+
+// Tests that a program can be loaded once and run separately, and that 
+// loading a second program replaces the first. 
+func TestLoadRun(t *testing.T) {
+    var output strings.Builder
+    vm := NewVirtualMachine(&output)
+    if err := vm.Load(strings.NewReader("LDI r1, 1\nLDI r2, 2\nADD r1, r2\nSTDOUT r1\n")); err != nil {
+        t.Fatalf("Error returned from valid program: %v", err)
+    }
+    if vm.VMem.Registers[0] != 4 || output.Len() != 0 {
+        t.Errorf("Expected 4 instructions and no output after Load, got %d instructions, output %q", vm.VMem.Registers[0], output.String())
+    }
+    if err := vm.Run(); err != nil {
+        t.Errorf("Error returned from Run: %v", err)
+    }
+    if output.String() != "3\n" {
+        t.Errorf("Expected output %q, got %q", "3\n", output.String())
+    }
+
+    vm = NewVirtualMachine(&output)
+    if err := vm.Load(strings.NewReader("LDI r1, 1\nLDI r2, 2\nSTDOUT r1\n")); err != nil {
+        t.Fatalf("Error returned from valid program: %v", err)
+    }
+    if err := vm.Load(strings.NewReader("NOP\n")); err != nil {
+        t.Fatalf("Error returned from valid program: %v", err)
+    }
+    if vm.VMem.Registers[0] != 1 || vm.VMem.CodeSize != 1 {
+        t.Errorf("Expected the second program to replace the first, got %d instructions", vm.VMem.CodeSize)
+    }
+    if err := vm.Load(strings.NewReader("LDI r1\n")); err == nil {
+        t.Errorf("No error returned from invalid program")
+    }
+    if vm.VMem.Registers[0] != 0 {
+        t.Errorf("Expected no program after a failed Load, got %d instructions", vm.VMem.Registers[0])
+    }
+}
+
+// Tests that a program can be executed one instruction at a time, and 
+// that Reset restores its initial state without re-parsing. 
+func TestStep(t *testing.T) {
+    var output strings.Builder
+    vm := NewVirtualMachine(&output)
+    if err := vm.Load(strings.NewReader("LDI r1, 3\nloop: SUBI r1, 1\nPUSH r1\nCMP r1, r9\nJNE loop\nHALT r1\nSTDOUT r1\n")); err != nil {
+        t.Fatalf("Error returned from valid program: %v", err)
+    }
+    if err := vm.Reset(); err != nil {
+        t.Fatalf("Error returned from Reset: %v", err)
+    }
+
+    // the PC of each instruction executed, ending with HALT 
+    trace := []int32{0, 1, 2, 3, 4, 1, 2, 3, 4, 1, 2, 3, 4, 5}
+    for run := 0; run < 2; run++ {
+        for i, pc := range trace {
+            state := vm.Interpreter.State()
+            if state.PC != pc || state.Steps != int64(i) {
+                t.Fatalf("run %d: step %d: expected PC %d, got PC %d after %d steps", run, i, pc, state.PC, state.Steps)
+            }
+            halted, err := vm.Step()
+            if err != nil || halted != (i == len(trace) - 1) {
+                t.Fatalf("run %d: step %d: halted %v: %v", run, i, halted, err)
+            }
+        }
+        if halted, err := vm.Step(); !halted || err != nil || vm.Interpreter.PC != 5 {
+            t.Errorf("run %d: expected no instruction after HALT, got halted %v at PC %d: %v", run, halted, vm.Interpreter.PC, err)
+        }
+        state := vm.Interpreter.State()
+        if state.Registers[1] != 0 || state.SP != int32(DATA_SIZE - 3) || !state.Halted {
+            t.Errorf("run %d: expected R1 0, 3 words on the stack and HALT, got %+v", run, state)
+        }
+        state.Registers[1] = 100
+        if vm.Interpreter.Registers[1] == 100 {
+            t.Errorf("run %d: changing the State changed the registers", run)
+        }
+
+        if err := vm.Reset(); err != nil {
+            t.Fatalf("Error returned from Reset: %v", err)
+        }
+        state = vm.Interpreter.State()
+        if state.PC != 0 || state.Steps != 0 || state.Halted || state.Registers[0] != 7 || state.SP != DATA_SIZE {
+            t.Errorf("run %d: state not restored by Reset: %+v", run, state)
+        }
+    }
+    if output.Len() != 0 {
+        t.Errorf("Expected no output, got %q", output.String())
+    }
+
+    vm = NewVirtualMachine(&output)
+    if err := vm.Load(strings.NewReader("LDI r1, 5\nHALT r1\n")); err != nil {
+        t.Fatalf("Error returned from valid program: %v", err)
+    }
+    if err := vm.Run(); err == nil {
+        t.Errorf("Expected exit status 5 from the first run")
+    }
+    var exitErr *ExitError
+    if err := vm.Run(); !errors.As(err, &exitErr) || exitErr.Status != 5 {
+        t.Errorf("Expected exit status 5 from the second run, got: %v", err)
+    }
+}
+
+// Tests that the text and JSON traces report each instruction executed 
+// and the registers it changed. 
+func TestTrace(t *testing.T) {
+    program := "LDI r1, 2 ; two\nloop: PUSH r1 # save\nPRINT \"a;b\"\nCMP r2, r1\nHALT\n"
+    testCases := []struct {
+        format string
+        expected string
+    }{
+        {TRACE_TEXT, "     1  addr 0: LDI r1, 2                R1: 0 -> 2\n" +
+            "     2  addr 1: PUSH r1                  SP: 1024 -> 1023\n" +
+            "     3  addr 2: PRINT \"a;b\"\n" +
+            "     4  addr 3: CMP r2, r1               FLAGS: 0 -> 2\n" +
+            "     5  addr 4: HALT\n"},
+        {TRACE_JSON, `{"step":1,"pc":0,"instr":"LDI r1, 2","changes":[{"reg":"R1","old":0,"new":2}]}` + "\n" +
+            `{"step":2,"pc":1,"instr":"PUSH r1","changes":[{"reg":"SP","old":1024,"new":1023}]}` + "\n" +
+            `{"step":3,"pc":2,"instr":"PRINT \"a;b\"","changes":[]}` + "\n" +
+            `{"step":4,"pc":3,"instr":"CMP r2, r1","changes":[{"reg":"FLAGS","old":0,"new":2}]}` + "\n" +
+            `{"step":5,"pc":4,"instr":"HALT","changes":[]}` + "\n"},
+    }
+
+    for _, testCase := range testCases {
+        var trace strings.Builder
+        vm := NewVirtualMachine(io.Discard)
+        vm.Trace = &trace
+        vm.TraceFormat = testCase.format
+        if err := vm.ExecuteString(program); err != nil {
+            t.Fatalf("FAIL: %s: error returned from valid program: %v", testCase.format, err)
+        }
+        if trace.String() != testCase.expected {
+            t.Errorf("FAIL: %s: expected trace:\n%s\ngot:\n%s", testCase.format, testCase.expected, trace.String())
+        }
+    }
+
+    vm := NewVirtualMachine(io.Discard)
+    vm.Trace = io.Discard
+    vm.TraceFormat = "xml"
+    if err := vm.ExecuteString("NOP\n"); err == nil || !strings.Contains(err.Error(), "unknown trace format 'xml'") {
+        t.Errorf("FAIL: expected unknown trace format error, got: %v", err)
+    }
+}
+
+// Tests that a program written to an object file runs as its source does.
+func TestObjectFile(t *testing.T) {
+    program := "LDI r1, 100000000\nloop: PRINT \"n=\"\nPRINTD r1\nPUTC r2\nSUBI r1, 40000000\nCMP r1, r0\nJGT loop\nHALT r1\n"
+    var output strings.Builder
+    source := NewVirtualMachine(&output)
+    if err := source.Load(strings.NewReader(program)); err != nil {
+        t.Fatalf("FAIL: error returned from valid program: %v", err)
+    }
+    var object strings.Builder
+    if err := source.WriteObject(&object); err != nil {
+        t.Fatalf("FAIL: error returned from WriteObject: %v", err)
+    }
+
+    vm := NewVirtualMachine(&output)
+    if err := vm.LoadObject(strings.NewReader(object.String())); err != nil {
+        t.Fatalf("FAIL: error returned from LoadObject: %v", err)
+    }
+    if vm.VMem.Registers[0] != 8 || vm.VMem.Labels["loop"] != 1 || vm.Instruction(6) != "JGT loop" {
+        t.Errorf("FAIL: expected 8 instructions and the label loop, got %d: %v", vm.VMem.Registers[0], vm.VMem.Labels)
+    }
+    var exitErr *ExitError
+    if err := vm.Run(); !errors.As(err, &exitErr) || exitErr.Status != -20000000 {
+        t.Errorf("FAIL: expected exit status -20000000, got: %v", err)
+    }
+    if output.String() != "n=100000000\x00n=60000000\x00n=20000000\x00" {
+        t.Errorf("FAIL: unexpected output %q", output.String())
+    }
+
+    // runtime errors have no source position, so the instruction is shown
+    if err := source.Load(strings.NewReader("LDI r1, 1\nDIVI r1, 0\n")); err != nil {
+        t.Fatalf("FAIL: error returned from valid program: %v", err)
+    }
+    object.Reset()
+    if err := source.WriteObject(&object); err != nil {
+        t.Fatalf("FAIL: error returned from WriteObject: %v", err)
+    }
+    if err := vm.ExecuteObject(strings.NewReader(object.String())); err == nil || !strings.HasSuffix(err.Error(), "divide by zero\n    at addr 1: DIVI r1, 0") {
+        t.Errorf("FAIL: expected divide by zero at addr 1, got: %v", err)
+    }
+    if err := vm.LoadObject(strings.NewReader(program)); err == nil || vm.VMem.Registers[0] != 0 {
+        t.Errorf("FAIL: expected source code to be rejected as an object file, got: %v", err)
+    }
+}